@@ -14,6 +14,7 @@ var ( // Main API
 	_ = ApproxNG
 	_ = ApproxAMAP
 	_ = ApproxBS
+	_ = ApproxBSRand
 	_ = ApproxKBT
 )
 
@@ -23,47 +24,11 @@ type XP struct {
 }
 
 func ApproxBT(spn SPN) []int {
-	prt := make([]float64, len(spn.Nodes))
-	branch := make([]int, len(spn.Nodes))
-	for i, n := range spn.Nodes {
-		switch n := n.(type) {
-		case *Trm:
-			prt[i] = 0
-		case *Sum:
-			eBest, pBest := -1, math.Inf(-1)
-			for _, e := range n.Edges {
-				crt := e.Weight + prt[e.Node.ID()]
-				if pBest < crt {
-					pBest = crt
-					eBest = e.Node.ID()
-				}
-			}
-			branch[i] = eBest
-			prt[i] = pBest
-		case *Prd:
-			val := 0.0
-			for _, e := range n.Edges {
-				val += prt[e.Node.ID()]
-			}
-			prt[i] = val
-		}
-	}
-
+	reach := spn.MaxTree(spn.MaxEvalX(freeX(len(spn.Schema))))
 	x := make([]int, len(spn.Schema))
-	reach := make([]bool, len(spn.Nodes))
-	reach[len(spn.Nodes)-1] = true
-	for i := len(spn.Nodes) - 1; i >= 0; i-- {
-		if reach[i] {
-			switch n := spn.Nodes[i].(type) {
-			case *Trm:
-				x[n.Kth] = n.Value
-			case *Sum:
-				reach[branch[i]] = true
-			case *Prd:
-				for _, e := range n.Edges {
-					reach[e.Node.ID()] = true
-				}
-			}
+	for i, n := range spn.Nodes {
+		if n, ok := n.(*Trm); ok && reach[i] {
+			x[n.Kth] = n.Value
 		}
 	}
 	return x
@@ -180,14 +145,23 @@ func evalAt(spn SPN, x []int, at int) float64 {
 
 func ApproxBS(spn SPN, beamSize int, timeout int) float64 {
 	ctx, _ := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	return bs(ctx, spn, prbK(spn, beamSize), beamSize).P
+	return bs(ctx, spn, prbK(spn, beamSize, rand.Float64), beamSize).P
 }
 
-func prbK(spn SPN, k int) []XP {
+// ApproxBSRand is ApproxBS with the beam seeded from r. Given the same seed
+// and model, it returns the same result as long as the search finishes
+// within timeout.
+func ApproxBSRand(spn SPN, beamSize int, timeout int, r *rand.Rand) float64 {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	return bs(ctx, spn, prbK(spn, beamSize, r.Float64), beamSize).P
+}
+
+func prbK(spn SPN, k int, uniform func() float64) []XP {
 	prt := partition(spn)
 	res := make([]XP, k)
 	for times := 0; times < k; times++ {
-		x := prb1(spn, prt)
+		x := prb1(spn, prt, uniform)
 		p := spn.EvalX(x)
 		res[times] = XP{x, p}
 	}
@@ -205,7 +179,9 @@ func partition(spn SPN) []float64 {
 	return spn.Eval(ass)
 }
 
-func prb1(spn SPN, prt []float64) []int {
+// prb1 samples an assignment top-down, choosing the children of sum nodes in
+// proportion to their weighted values prt.
+func prb1(spn SPN, prt []float64, uniform func() float64) []int {
 	x := make([]int, len(spn.Schema))
 	reach := make([]bool, len(spn.Nodes))
 	reach[len(spn.Nodes)-1] = true
@@ -215,7 +191,7 @@ func prb1(spn SPN, prt []float64) []int {
 			case *Trm:
 				x[n.Kth] = n.Value
 			case *Sum:
-				r := math.Log(rand.Float64()) + prt[i]
+				r := math.Log(uniform()) + prt[i]
 				crt := math.Inf(-1)
 				for _, e := range n.Edges {
 					crt = logSumExp(crt, e.Weight+prt[e.Node.ID()])
@@ -320,15 +296,16 @@ func nextGenD(xp XP, spn SPN, ch chan []XP) {
 }
 
 func ApproxKBT(spn SPN, k int, timeout int) float64 {
-	xs := kbt(spn, k, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	xs := kbt(ctx, spn, k)
 	if len(xs) == 0 {
 		return math.NaN()
 	}
 	return maxXP(evalXBatch(spn, xs)).P
 }
 
-func kbt(spn SPN, k int, timeout int) [][]int {
-	ctx, _ := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+func kbt(ctx context.Context, spn SPN, k int) [][]int {
 	ls := make([][]*link, len(spn.Nodes))
 	for i, n := range spn.Nodes {
 		select {
@@ -370,10 +347,15 @@ func maxXP(xps []XP) XP {
 	return max
 }
 
+// evalXChunk is the number of assignments evalXBatch evaluates at once, which
+// bounds its buffers to evalXChunk values per node.
+const evalXChunk = 64
+
 func evalXBatch(spn SPN, xs [][]int) []XP {
+	ps := EvalXBatch(spn, xs, 1, evalXChunk)
 	xps := make([]XP, len(xs))
 	for i, x := range xs {
-		xps[i] = XP{x, spn.EvalX(x)}
+		xps[i] = XP{x, ps[i]}
 	}
 	return xps
 }
@@ -0,0 +1,104 @@
+package maxspn
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"time"
+)
+
+var ( // Main API
+	_ = ExactAStar
+)
+
+// Exact solver with best-first (A*) search, using UpperBound of a partial
+// assignment as heuristic. When more than maxQueue partial assignments are
+// open, the remaining ones are solved depth-first by ExactFCnOnS's search.
+// On timeout, Upper is the bound of the best open partial assignment.
+func ExactAStar(spn SPN, baseline float64, timeout int, maxQueue int) Bound {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	f := Compile(spn)
+	x := freeX(len(spn.Schema))
+	fringe := &xpHeap{}
+	heap.Push(fringe, XP{x, f.upperBound(x)})
+	for fringe.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			return Bound{baseline, math.Max(baseline, (*fringe)[0].P)}
+		default:
+		}
+		if fringe.Len() > maxQueue {
+			return dfsFringe(ctx, spn, *fringe, baseline)
+		}
+		xp := heap.Pop(fringe).(XP)
+		if xp.P <= baseline {
+			return Bound{baseline, baseline}
+		}
+		varID := -1
+		d := f.derivativeOfAssignmentX(xp.X)
+		for i := range xp.X {
+			if xp.X[i] == -1 && (varID == -1 || maxF(d[varID]) < maxF(d[i])) {
+				varID = i
+			}
+		}
+		if varID == -1 {
+			// the bound of a complete assignment is its value
+			return Bound{xp.P, xp.P}
+		}
+		for v := range d[varID] {
+			if d[varID][v] <= baseline {
+				continue
+			}
+			nx := make([]int, len(xp.X))
+			copy(nx, xp.X)
+			nx[varID] = v
+			if p := f.upperBound(nx); p > baseline {
+				heap.Push(fringe, XP{nx, p})
+			}
+		}
+	}
+	return Bound{baseline, baseline}
+}
+
+func dfsFringe(ctx context.Context, spn SPN, fringe xpHeap, baseline float64) Bound {
+	s := &search{ctx: ctx, order: OrderMaxDerivative, fc: true, stage: true, budget: -1, open: math.Inf(-1)}
+	net := s.network(spn)
+	for fringe.Len() > 0 {
+		xp := heap.Pop(&fringe).(XP)
+		if xp.P <= baseline {
+			break
+		}
+		if s.aborted {
+			// the rest of the fringe has smaller bounds
+			s.open = math.Max(s.open, xp.P)
+			break
+		}
+		baseline = s.dfs(net, xp.X, xp.P, baseline)
+	}
+	return s.bound(baseline)
+}
+
+func maxF(fs []float64) float64 {
+	max := math.Inf(-1)
+	for _, f := range fs {
+		max = math.Max(max, f)
+	}
+	return max
+}
+
+type xpHeap []XP
+
+func (h xpHeap) Len() int           { return len(h) }
+func (h xpHeap) Less(i, j int) bool { return h[i].P > /* MaxHeap */ h[j].P }
+func (h xpHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *xpHeap) Push(x interface{}) {
+	*h = append(*h, x.(XP))
+}
+
+func (h *xpHeap) Pop() interface{} {
+	n := len(*h)
+	r := (*h)[n-1]
+	*h = (*h)[0 : n-1]
+	return r
+}
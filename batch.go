@@ -0,0 +1,137 @@
+package maxspn
+
+import (
+	"math"
+	"sync"
+)
+
+var ( // Main API
+	_ = NewBatch
+	_ = EvalXBatch
+)
+
+// Batch evaluates an SPN on many assignments in one pass over Nodes. Node
+// values are laid out node-major, val[i*size+b] for node i and assignment b,
+// and the buffers are reused between calls. A Batch is not safe for
+// concurrent use; see EvalXBatch.
+type Batch struct {
+	spn SPN
+	val []float64
+	max []float64
+	sum []float64
+}
+
+func NewBatch(spn SPN) *Batch {
+	return &Batch{spn: spn}
+}
+
+// EvalX returns the root values of the assignments xs, -1 for marginalized
+// variables as in X2Ass.
+func (b *Batch) EvalX(xs [][]int) []float64 {
+	return b.eval(len(xs), func(t *Trm, k int) float64 {
+		if x := xs[k][t.Kth]; x == -1 || x == t.Value {
+			return 0
+		}
+		return math.Inf(-1)
+	})
+}
+
+// Eval returns the root values of the soft assignments asses, each as in
+// SPN.Eval.
+func (b *Batch) Eval(asses [][][]float64) []float64 {
+	return b.eval(len(asses), func(t *Trm, k int) float64 {
+		return math.Log(asses[k][t.Kth][t.Value])
+	})
+}
+
+func (b *Batch) eval(size int, leaf func(t *Trm, k int) float64) []float64 {
+	nn := len(b.spn.Nodes)
+	if cap(b.val) < nn*size {
+		b.val = make([]float64, nn*size)
+		b.max = make([]float64, size)
+		b.sum = make([]float64, size)
+	}
+	val, max, sum := b.val[:nn*size], b.max[:size], b.sum[:size]
+	for i, n := range b.spn.Nodes {
+		vi := val[i*size : (i+1)*size]
+		switch n := n.(type) {
+		case *Trm:
+			for k := range vi {
+				vi[k] = leaf(n, k)
+			}
+		case *Sum:
+			for k := range max {
+				max[k] = math.Inf(-1)
+				sum[k] = 0
+			}
+			for _, e := range n.Edges {
+				ve := val[e.Node.ID()*size : (e.Node.ID()+1)*size]
+				for k := range max {
+					max[k] = math.Max(max[k], e.Weight+ve[k])
+				}
+			}
+			for _, e := range n.Edges {
+				ve := val[e.Node.ID()*size : (e.Node.ID()+1)*size]
+				for k := range sum {
+					if !math.IsInf(max[k], 0) {
+						sum[k] += math.Exp(e.Weight + ve[k] - max[k])
+					}
+				}
+			}
+			for k := range vi {
+				if math.IsInf(max[k], 0) {
+					vi[k] = max[k]
+				} else {
+					vi[k] = math.Log(sum[k]) + max[k]
+				}
+			}
+		case *Prd:
+			for k := range vi {
+				vi[k] = 0
+			}
+			for _, e := range n.Edges {
+				ve := val[e.Node.ID()*size : (e.Node.ID()+1)*size]
+				for k := range vi {
+					vi[k] += ve[k]
+				}
+			}
+		}
+	}
+	res := make([]float64, size)
+	copy(res, val[(nn-1)*size:])
+	return res
+}
+
+// EvalXBatch evaluates xs like Batch.EvalX, splitting them across workers
+// goroutines, each evaluating chunks of at most chunk assignments.
+func EvalXBatch(spn SPN, xs [][]int, workers int, chunk int) []float64 {
+	if workers < 1 {
+		workers = 1
+	}
+	if chunk < 1 {
+		chunk = len(xs)
+	}
+	res := make([]float64, len(xs))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b := NewBatch(spn)
+			for lo := range jobs {
+				hi := lo + chunk
+				if hi > len(xs) {
+					hi = len(xs)
+				}
+				copy(res[lo:hi], b.EvalX(xs[lo:hi]))
+			}
+		}()
+	}
+	for lo := 0; lo < len(xs); lo += chunk {
+		jobs <- lo
+	}
+	close(jobs)
+	wg.Wait()
+	return res
+}
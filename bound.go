@@ -0,0 +1,119 @@
+package maxspn
+
+import "math"
+
+var ( // Main API
+	_ = UpperBound
+	_ = Bounds
+)
+
+// Bound brackets the log MAP value of an SPN.
+type Bound struct {
+	Lower float64 // value of the incumbent returned by a solver
+	Upper float64
+}
+
+// Gap is the distance between the bounds in log domain, i.e. the incumbent
+// is within a factor exp(Gap) of the optimum.
+func (b Bound) Gap() float64 {
+	return b.Upper - b.Lower
+}
+
+// UpperBound bounds the log MAP value of spn under the partial assignment x
+// (-1 for free variables, nil for none). Note that the max-product value of
+// ApproxBT is a lower bound on non-selective SPNs, so the bound is taken as
+// the smaller of a sum-max pass (see sumMax) and the forward-checking
+// derivatives: for every variable k, max_v P(X_k = v, rest marginalized) is
+// at least the MAP value.
+func UpperBound(spn SPN, x []int) float64 {
+	return Compile(spn).upperBound(x)
+}
+
+func (f *Flat) upperBound(x []int) float64 {
+	if x == nil {
+		x = freeX(len(f.Schema))
+	}
+	d := f.derivativeOfAssignmentX(x)
+	ub := f.sumMax(x)
+	for i := range d {
+		if x[i] != -1 {
+			ub = math.Min(ub, d[i][x[i]])
+			continue
+		}
+		ub = math.Min(ub, maxF(d[i]))
+	}
+	return ub
+}
+
+// sumMax is an upward pass that maximizes products and sums over maxima of
+// children, since max(a+b) <= max(a)+max(b). Nodes over a single variable are
+// kept as a vector over its values and maximized exactly when they are used
+// by a node over several variables.
+func (f *Flat) sumMax(x []int) float64 {
+	kth := make([]int32, len(f.Kind)) // -1 if the scope has several variables
+	vec := make([][]float64, len(f.Kind))
+	val := make([]float64, len(f.Kind))
+	max := func(i int32) float64 {
+		if kth[i] == -1 {
+			return val[i]
+		}
+		return maxF(vec[i])
+	}
+	for i, k := range f.Kind {
+		lo, hi := f.Start[i], f.Start[i+1]
+		if k == KindTrm {
+			kth[i] = f.Kth[i]
+			vec[i] = make([]float64, f.Schema[kth[i]])
+			for v := range vec[i] {
+				if v != int(f.Value[i]) || (x[kth[i]] != -1 && x[kth[i]] != v) {
+					vec[i][v] = math.Inf(-1)
+				}
+			}
+			continue
+		}
+		kth[i] = kth[f.Child[lo]]
+		for j := lo; j < hi; j++ {
+			if kth[f.Child[j]] != kth[i] {
+				kth[i] = -1
+			}
+		}
+		switch {
+		case k == KindSum && kth[i] != -1:
+			vec[i] = make([]float64, f.Schema[kth[i]])
+			for v := range vec[i] {
+				vec[i][v] = logSumExpF(int(hi-lo), func(j int) float64 {
+					return f.Weight[int(lo)+j] + vec[f.Child[int(lo)+j]][v]
+				})
+			}
+		case k == KindSum:
+			val[i] = logSumExpF(int(hi-lo), func(j int) float64 {
+				return f.Weight[int(lo)+j] + max(f.Child[int(lo)+j])
+			})
+		case kth[i] != -1:
+			vec[i] = make([]float64, f.Schema[kth[i]])
+			for j := lo; j < hi; j++ {
+				for v := range vec[i] {
+					vec[i][v] += vec[f.Child[j]][v]
+				}
+			}
+		default:
+			for j := lo; j < hi; j++ {
+				val[i] += max(f.Child[j])
+			}
+		}
+	}
+	return max(int32(len(f.Kind) - 1))
+}
+
+// Bounds reports the bounds of an incumbent value returned by a solver that
+// reports only a value, e.g. ApproxKBT, ApproxAMAP(...).P, ExactFCnOnS or
+// spn.EvalX(ApproxBT(spn)), with UpperBound of the whole network. Exact,
+// ExactAStar and ExactRestarts report tighter bounds from their open
+// branches. A NaN value (solver timed out without an incumbent) is reported
+// as -Inf.
+func Bounds(spn SPN, lower float64) Bound {
+	if math.IsNaN(lower) {
+		lower = math.Inf(-1)
+	}
+	return Bound{Lower: lower, Upper: math.Max(lower, UpperBound(spn, nil))}
+}
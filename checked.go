@@ -0,0 +1,96 @@
+package maxspn
+
+import (
+	"fmt"
+	"math"
+)
+
+// EvalError reports the first node whose value is NaN or +Inf.
+type EvalError struct {
+	Node    int
+	Value   float64
+	Weights []float64 // edge weights of a sum node
+	Inputs  []float64 // values of the children, or the indicator of a leaf
+}
+
+func (e *EvalError) Error() string {
+	return fmt.Sprintf("maxspn: node %d evaluates to %v (weights %v, inputs %v)", e.Node, e.Value, e.Weights, e.Inputs)
+}
+
+func (spn SPN) EvalXChecked(x []int) (float64, error) {
+	val, err := spn.EvalChecked(X2Ass(x, spn.Schema))
+	if err != nil {
+		return math.NaN(), err
+	}
+	return val[len(val)-1], nil
+}
+
+// EvalChecked is Eval that stops at the first NaN or +Inf node value, which
+// would otherwise silently propagate to the root. Sums use compensated
+// summation, which keeps precision on nodes with thousands of children.
+func (spn SPN) EvalChecked(ass [][]float64) ([]float64, error) {
+	val := make([]float64, len(spn.Nodes))
+	for i, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Trm:
+			val[i] = math.Log(ass[n.Kth][n.Value])
+		case *Sum:
+			val[i] = logSumExpKahanF(len(n.Edges), func(k int) float64 {
+				return n.Edges[k].Weight + val[n.Edges[k].Node.ID()]
+			})
+		case *Prd:
+			prd := 0.0
+			for _, e := range n.Edges {
+				prd += val[e.Node.ID()]
+			}
+			val[i] = prd
+		}
+		if math.IsNaN(val[i]) || math.IsInf(val[i], 1) {
+			return val, evalError(n, val, ass)
+		}
+	}
+	return val, nil
+}
+
+// evalError describes the failing node n given the values val of its
+// children.
+func evalError(n Node, val []float64, ass [][]float64) *EvalError {
+	e := &EvalError{Node: n.ID(), Value: val[n.ID()]}
+	switch n := n.(type) {
+	case *Trm:
+		e.Inputs = []float64{ass[n.Kth][n.Value]}
+	case *Sum:
+		for _, se := range n.Edges {
+			e.Weights = append(e.Weights, se.Weight)
+			e.Inputs = append(e.Inputs, val[se.Node.ID()])
+		}
+	case *Prd:
+		for _, pe := range n.Edges {
+			e.Inputs = append(e.Inputs, val[pe.Node.ID()])
+		}
+	}
+	return e
+}
+
+// logSumExpKahanF is logSumExpF with Neumaier compensated summation.
+func logSumExpKahanF(n int, f func(i int) float64) float64 {
+	max := math.Inf(-1)
+	for i := 0; i < n; i++ {
+		max = math.Max(max, f(i))
+	}
+	if math.IsInf(max, 0) {
+		return max
+	}
+	sum, c := 0.0, 0.0
+	for i := 0; i < n; i++ {
+		v := math.Exp(f(i) - max)
+		t := sum + v
+		if math.Abs(sum) >= math.Abs(v) {
+			c += (sum - t) + v
+		} else {
+			c += (v - t) + sum
+		}
+		sum = t
+	}
+	return math.Log(sum+c) + max
+}
@@ -0,0 +1,38 @@
+// Command llscore reports the log-likelihood of a dataset under an SPN.
+//
+//	llscore -spn model.spn -data test.data [-rows] [-workers 4]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"runtime"
+
+	"github.com/shtechair/maxspn"
+)
+
+func main() {
+	spnFile := flag.String("spn", "", "SPN file")
+	dataFile := flag.String("data", "", "data file, CSV or sparse k:v")
+	rows := flag.Bool("rows", false, "print the log-likelihood of every row")
+	workers := flag.Int("workers", runtime.NumCPU(), "number of goroutines")
+	flag.Parse()
+	if *spnFile == "" || *dataFile == "" {
+		flag.Usage()
+		log.Fatal("llscore: -spn and -data are required")
+	}
+
+	spn := maxspn.LoadSPN(*spnFile)
+	xs := maxspn.LoadData(*dataFile, len(spn.Schema))
+	s := maxspn.LogLikelihood(spn, xs, *workers)
+	if *rows {
+		for i, p := range s.Rows {
+			fmt.Println(i, p)
+		}
+	}
+	fmt.Println("rows", len(xs))
+	fmt.Println("total", s.Total)
+	fmt.Println("mean", s.Mean)
+	fmt.Println("zero", len(s.Zero), s.Zero)
+}
@@ -0,0 +1,59 @@
+package maxspn
+
+var ( // Main API
+	_ = Condition
+	_ = QueryVarMap
+)
+
+// VarMap relates the variables of a network reduced by Condition or MAP2MAX
+// to the variables of the original network.
+type VarMap struct {
+	Orig []int // original index of every variable of the reduced network
+	Full []int // original assignment, -1 for reduced and marginalized variables
+}
+
+// Condition returns spn conditioned on e, i.e. with the observed variables
+// folded into weights, over the unobserved variables only, and the mapping of
+// its variables. EvalX values of the reduced network are the joint values
+// with e. At least one variable must be unobserved.
+func Condition(spn SPN, e Evidence) (SPN, VarMap) {
+	return Reduce(spn, e.X(spn.Schema))
+}
+
+// QueryVarMap is the mapping of the variables of MAP2MAX(spn, q): '?'
+// variables are reduced, '0'-'9' observed, and '*' marginalized.
+func QueryVarMap(q []byte) VarMap {
+	m := VarMap{Full: make([]int, len(q))}
+	for i, c := range q {
+		switch c {
+		case '?':
+			m.Orig = append(m.Orig, i)
+			m.Full[i] = -1
+		case '*':
+			m.Full[i] = -1
+		default:
+			m.Full[i] = int(c - '0')
+		}
+	}
+	return m
+}
+
+// Lift returns the assignment of the original variables given the
+// assignment x of the reduced ones.
+func (m VarMap) Lift(x []int) []int {
+	full := make([]int, len(m.Full))
+	copy(full, m.Full)
+	for i, k := range m.Orig {
+		full[k] = x[i]
+	}
+	return full
+}
+
+// Project returns the assignment of the reduced variables in full.
+func (m VarMap) Project(full []int) []int {
+	x := make([]int, len(m.Orig))
+	for i, k := range m.Orig {
+		x[i] = full[k]
+	}
+	return x
+}
@@ -0,0 +1,78 @@
+package maxspn
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"math"
+)
+
+var ( // Main API
+	_ = LoadData
+	_ = LogLikelihood
+)
+
+// LoadData reads one assignment of the nvar variables per line, either as
+// comma separated values with -1 (or ?) for missing ones, or sparse as
+// space separated k:v pairs, where variables not listed are 0.
+func LoadData(filename string, nvar int) [][]int {
+	bs, err := ioutil.ReadFile(filename)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var xs [][]int
+	for _, ln := range bytes.Split(bs, []byte("\n")) {
+		ln = bytes.TrimSpace(ln)
+		if len(ln) == 0 {
+			continue
+		}
+		x := make([]int, nvar)
+		if bytes.IndexByte(ln, ':') >= 0 {
+			for _, kv := range bytes.Fields(ln) {
+				p := bytes.SplitN(kv, []byte(":"), 2)
+				if len(p) != 2 {
+					log.Fatalf("LoadData: bad sparse entry %q", kv)
+				}
+				k := parseInt(string(p[0]))
+				if k < 0 || k >= nvar {
+					log.Fatalf("LoadData: bad sparse entry %q", kv)
+				}
+				x[k] = parseInt(string(p[1]))
+			}
+		} else {
+			vs := bytes.Split(ln, []byte(","))
+			if len(vs) != nvar {
+				log.Fatalf("LoadData: %d values in a row of %d variables", len(vs), nvar)
+			}
+			for i, v := range vs {
+				if v = bytes.TrimSpace(v); string(v) == "?" {
+					x[i] = -1
+				} else {
+					x[i] = parseInt(string(v))
+				}
+			}
+		}
+		xs = append(xs, x)
+	}
+	return xs
+}
+
+type Score struct {
+	Mean  float64   // average log-likelihood of the rows
+	Total float64   // sum of the log-likelihoods
+	Rows  []float64 // log-likelihood of each row
+	Zero  []int     // rows with probability 0
+}
+
+// LogLikelihood scores the assignments xs in batch on workers goroutines.
+func LogLikelihood(spn SPN, xs [][]int, workers int) Score {
+	s := Score{Rows: EvalXBatch(spn, xs, workers, 256)}
+	for i, p := range s.Rows {
+		if math.IsInf(p, -1) {
+			s.Zero = append(s.Zero, i)
+		}
+		s.Total += p
+	}
+	s.Mean = s.Total / float64(len(xs))
+	return s
+}
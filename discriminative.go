@@ -0,0 +1,89 @@
+package maxspn
+
+import "math"
+
+var ( // Main API
+	_ = Discriminative
+)
+
+// Discriminative fits the sum weights of spn to maximize the conditional
+// log-likelihood log P(query | evidence) of the complete assignments xs,
+// where the variables in query are predicted from all the others, as in
+// MAP2MAX. The weights are first normalized by Normalize, which keeps the
+// distribution, then kept locally normalized by a softmax parameterization
+// and updated by gradient ascent with learning rate lr after every
+// mini-batch of batch rows. The gradient of log S(x) with respect
+// to a log weight is the posterior of its edge, computed from Derivative. It
+// returns the fitted SPN with the average conditional log-likelihood of xs
+// after every epoch.
+func Discriminative(spn SPN, xs [][]int, query []int, lr float64, batch int, epochs int) (SPN, []float64) {
+	spn, _ = Normalize(spn)
+	f := Compile(spn)
+	if batch <= 0 || batch > len(xs) {
+		batch = len(xs)
+	}
+	es := make([][]int, len(xs))
+	for i, x := range xs {
+		es[i] = append([]int(nil), x...)
+		for _, k := range query {
+			es[i][k] = -1
+		}
+	}
+	var trace []float64
+	for epoch := 0; epoch < epochs; epoch++ {
+		for lo := 0; lo < len(xs); lo += batch {
+			hi := lo + batch
+			if hi > len(xs) {
+				hi = len(xs)
+			}
+			pos := f.expectedCounts(xs[lo:hi])
+			neg := f.expectedCounts(es[lo:hi])
+			for j := range pos {
+				pos[j] = (pos[j] - neg[j]) / float64(hi-lo)
+			}
+			f.ascend(pos, lr)
+		}
+		cll := 0.0
+		for i := range xs {
+			cll += f.EvalX(xs[i]) - f.EvalX(es[i])
+		}
+		trace = append(trace, cll/float64(len(xs)))
+	}
+	return f.SPN(), trace
+}
+
+// ascend takes a gradient step on the softmax parameters of every sum node,
+// given the gradient g with respect to its normalized log weights.
+func (f *Flat) ascend(g []float64, lr float64) {
+	for i, k := range f.Kind {
+		if k != KindSum {
+			continue
+		}
+		lo, hi := f.Start[i], f.Start[i+1]
+		tot := 0.0
+		for j := lo; j < hi; j++ {
+			tot += g[j]
+		}
+		for j := lo; j < hi; j++ {
+			f.Weight[j] += lr * (g[j] - math.Exp(f.Weight[j])*tot)
+		}
+	}
+	f.normalizeLocally()
+}
+
+// normalizeLocally makes the weights of every sum node sum to 1.
+func (f *Flat) normalizeLocally() {
+	for i, k := range f.Kind {
+		if k != KindSum {
+			continue
+		}
+		lo, hi := f.Start[i], f.Start[i+1]
+		z := logSumExp(f.Weight[lo:hi]...)
+		if math.IsInf(z, 0) {
+			continue
+		}
+		for j := lo; j < hi; j++ {
+			f.Weight[j] -= z
+		}
+	}
+}
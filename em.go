@@ -0,0 +1,95 @@
+package maxspn
+
+import "math"
+
+var ( // Main API
+	_ = EM
+)
+
+// EM fits the sum weights of spn to the assignments xs (-1 for missing
+// values) by expectation-maximization and returns the fitted SPN with the
+// average log-likelihood of xs after every epoch. Expected edge counts are
+// computed from Eval and Derivative and smoothed with alpha. With batch < len(xs)
+// the weights are updated by stepwise EM after every mini-batch; otherwise
+// each epoch is one EM step. It stops after epochs epochs or when the
+// average log-likelihood improves by less than tol.
+func EM(spn SPN, xs [][]int, alpha float64, batch int, epochs int, tol float64) (SPN, []float64) {
+	f := Compile(spn)
+	if batch <= 0 || batch > len(xs) {
+		batch = len(xs)
+	}
+	stats := make([]float64, len(f.Weight))
+	var trace []float64
+	step := 0
+	for epoch := 0; epoch < epochs; epoch++ {
+		for lo := 0; lo < len(xs); lo += batch {
+			hi := lo + batch
+			if hi > len(xs) {
+				hi = len(xs)
+			}
+			cnt := f.expectedCounts(xs[lo:hi])
+			eta := 1.0
+			if batch < len(xs) {
+				eta = math.Pow(float64(step+2), -0.7)
+			}
+			for j := range stats {
+				// counts are scaled to the whole dataset
+				stats[j] = (1-eta)*stats[j] + eta*cnt[j]*float64(len(xs))/float64(hi-lo)
+			}
+			f.maximize(stats, alpha)
+			step++
+		}
+		ll := 0.0
+		for _, x := range xs {
+			ll += f.EvalX(x)
+		}
+		trace = append(trace, ll/float64(len(xs)))
+		if n := len(trace); n > 1 && trace[n-1]-trace[n-2] < tol {
+			break
+		}
+	}
+	return f.SPN(), trace
+}
+
+// expectedCounts sums over xs the posterior probability of every sum edge
+// i->c, P(x)^-1 * dP/dS_i * w_ic * S_c(x).
+func (f *Flat) expectedCounts(xs [][]int) []float64 {
+	cnt := make([]float64, len(f.Weight))
+	root := len(f.Kind) - 1
+	for _, x := range xs {
+		val := f.Eval(X2Ass(x, f.Schema))
+		if math.IsInf(val[root], -1) {
+			continue
+		}
+		dr := f.derivative(val)
+		for i, k := range f.Kind {
+			if k == KindSum {
+				for j := f.Start[i]; j < f.Start[i+1]; j++ {
+					cnt[j] += math.Exp(dr[i] + f.Weight[j] + val[f.Child[j]] - val[root])
+				}
+			}
+		}
+	}
+	return cnt
+}
+
+// maximize sets the log weights of every sum node to its smoothed normalized
+// edge counts.
+func (f *Flat) maximize(cnt []float64, alpha float64) {
+	for i, k := range f.Kind {
+		if k != KindSum {
+			continue
+		}
+		lo, hi := f.Start[i], f.Start[i+1]
+		tot := 0.0
+		for j := lo; j < hi; j++ {
+			tot += cnt[j] + alpha
+		}
+		if tot == 0 {
+			continue
+		}
+		for j := lo; j < hi; j++ {
+			f.Weight[j] = math.Log((cnt[j] + alpha) / tot)
+		}
+	}
+}
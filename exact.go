@@ -2,7 +2,6 @@ package maxspn
 
 import (
 	"context"
-	"log"
 	"math"
 	"time"
 )
@@ -44,182 +43,161 @@ func dfsMC(ctx context.Context, spn SPN, x []int, xi int, baseline float64) floa
 
 // Exact solver with Forwarding Checking
 func ExactFC(spn SPN, baseline float64, timeout int) float64 {
-	x := make([]int, len(spn.Schema))
-	for i := range x {
-		x[i] = -1
-	}
-	ctx, _ := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	return dfsFC(ctx, spn, x, baseline)
+	return Exact(spn, baseline, timeout, OrderFirst, true, false, 0).Lower
 }
 
-func dfsFC(ctx context.Context, spn SPN, x []int, baseline float64) float64 {
-	select {
-	case <-ctx.Done():
-		return baseline
-	default:
-	}
+// Exact solver with Forward Checking + Ordering
+func ExactFCnO(spn SPN, baseline float64, timeout int) float64 {
+	return Exact(spn, baseline, timeout, OrderMaxDerivative, true, false, 0).Lower
+}
 
-	x2 := make([]int, len(x))
-	copy(x2, x)
-	x = x2
-	as := make([][]float64, len(x))
-	for i := range as {
-		as[i] = make([]float64, 2)
-		if x[i] == 0 || x[i] == -1 {
-			as[i][0] = 1
-		}
-		if x[i] == 1 || x[i] == -1 {
-			as[i][1] = 1
-		}
-	}
-	var d [][]float64
-	for {
-		updated := false
-		d = derivativeOfAssignment(spn, as)
-		for i := range x {
-			if x[i] == -1 {
-				xi0 := d[i][0]
-				xi1 := d[i][1]
+// Exact solver with Forward Checking + Ordering + Stage
+func ExactFCnOnS(spn SPN, baseline float64, timeout int) float64 {
+	return Exact(spn, baseline, timeout, OrderMaxDerivative, true, true, 0).Lower
+}
 
-				if xi0 < baseline && xi1 < baseline {
-					return baseline
-				}
-				if xi0 < baseline {
-					x[i] = 1
-					as[i][0] = 0
-					updated = true
-				}
-				if xi1 < baseline {
-					x[i] = 0
-					as[i][1] = 0
-					updated = true
-				}
-			}
-		}
-		if !updated {
-			break
+// Exact solver with a branching strategy, optionally with forward checking
+// (fc) and staging the network once enough variables are assigned (stg).
+// Without forward checking, a branch is pruned only when its marginal is not
+// above baseline. Values of solved subproblems are cached in a transposition
+// table of at most memo entries, 0 to disable it. Lower is the larger of
+// baseline and the MAP value found. Upper equals Lower when the search
+// finished, and otherwise is the largest bound of the branches left open.
+func Exact(spn SPN, baseline float64, timeout int, order Order, fc bool, stg bool, memo int) Bound {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	s := &search{ctx: ctx, order: order, fc: fc, stage: stg, budget: -1, memo: newMemo(memo), open: math.Inf(-1)}
+	return s.bound(s.dfs(s.network(spn), freeX(len(spn.Schema)), math.NaN(), baseline))
+}
+
+// Exact solver with restarts: the search is restarted with a doubling budget
+// of visited nodes, starting from budget, keeping the best value as baseline,
+// and after restarts runs it completes without budget. Useful with
+// OrderRandom. The transposition table of at most memo entries is shared by
+// the runs, so subproblems solved before a restart are not searched again.
+// The bounds are those of the last run, see Exact.
+func ExactRestarts(spn SPN, baseline float64, timeout int, order Order, restarts int, budget int, memo int) Bound {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	m := newMemo(memo)
+	var net *network
+	var s *search
+	for r := 0; r <= restarts; r++ {
+		if r == restarts {
+			budget = -1
 		}
-	}
-	maxVarID := -1
-	maxValID := 0
-	for i := range x {
-		if x[i] == -1 {
-			maxVarID = i
-			break
+		s = &search{ctx: ctx, order: order, fc: true, stage: true, budget: budget, memo: m, open: math.Inf(-1)}
+		if net == nil {
+			net = s.network(spn)
 		}
+		baseline = s.dfs(net, freeX(len(spn.Schema)), math.NaN(), baseline)
+		budget *= 2
 	}
-	if i := maxVarID; i != -1 {
-		x[i] = maxValID
-		baseline = math.Max(dfsFC(ctx, spn, x, baseline), baseline)
-		x[i] = maxValID ^ 1
-		baseline = math.Max(dfsFC(ctx, spn, x, baseline), baseline)
-		return baseline
-	}
-	return math.Max(baseline, math.Max(d[0][0], d[0][1]))
+	return s.bound(baseline)
 }
 
-// Exact solver with Forward Checking + Ordering
-func ExactFCnO(spn SPN, baseline float64, timeout int) float64 {
-	x := make([]int, len(spn.Schema))
-	for i := range x {
-		x[i] = -1
-	}
-	ctx, _ := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	return dfsFCnO(ctx, spn, x, baseline)
+type search struct {
+	ctx     context.Context
+	order   Order
+	fc      bool
+	stage   bool
+	budget  int // nodes left to visit, -1 for unlimited
+	memo    *memo
+	aborted bool    // by timeout or budget, values are no longer proven
+	open    float64 // largest upper bound of the branches left unexplored
+	best    []int   // assignment of the best value found, nil if none beat the baseline
 }
 
-func dfsFCnO(ctx context.Context, spn SPN, x []int, baseline float64) float64 {
-	select {
-	case <-ctx.Done():
-		return baseline
-	default:
+// bound is the Bound of the value lower returned by dfs.
+func (s *search) bound(lower float64) Bound {
+	if !s.aborted {
+		return Bound{lower, lower}
 	}
+	return Bound{lower, math.Max(lower, s.open)}
+}
 
-	x2 := make([]int, len(x))
-	copy(x2, x)
-	x = x2
-	as := make([][]float64, len(x))
-	for i := range as {
-		as[i] = make([]float64, 2)
-		if x[i] == 0 || x[i] == -1 {
-			as[i][0] = 1
-		}
-		if x[i] == 1 || x[i] == -1 {
-			as[i][1] = 1
-		}
-	}
-	var d [][]float64
-	for {
-		updated := false
-		d = derivativeOfAssignment(spn, as)
-		for i := range x {
-			if x[i] == -1 {
-				xi0 := d[i][0]
-				xi1 := d[i][1]
+// leave aborts the search of net under x, which is left unexplored with
+// upper bound ub, computed if NaN.
+func (s *search) leave(net *network, x []int, ub float64, baseline float64) float64 {
+	s.aborted = true
+	if math.IsNaN(ub) {
+		ub = net.flat.upperBound(x)
+	}
+	s.open = math.Max(s.open, ub)
+	return baseline
+}
 
-				if xi0 < baseline && xi1 < baseline {
-					return baseline
-				}
-				if xi0 < baseline {
-					x[i] = 1
-					as[i][0] = 0
-					updated = true
-				}
-				if xi1 < baseline {
-					x[i] = 0
-					as[i][1] = 0
-					updated = true
-				}
-			}
-		}
-		if !updated {
-			break
-		}
-	}
-	maxVarID := -1
-	maxValID := -1
-	maxDer := math.Inf(-1)
-	for i := range x {
-		if x[i] == -1 {
-			crtValID := 0
-			crtDer := d[i][0]
-			if d[i][0] < d[i][1] {
-				crtValID = 1
-				crtDer = d[i][1]
-			}
-			if maxVarID == -1 || maxDer < crtDer {
-				maxVarID = i
-				maxValID = crtValID
-				maxDer = crtDer
-			}
-		}
-	}
-	if i := maxVarID; i != -1 {
-		x[i] = maxValID
-		baseline = math.Max(dfsFCnO(ctx, spn, x, baseline), baseline)
-		x[i] = maxValID ^ 1
-		baseline = math.Max(dfsFCnO(ctx, spn, x, baseline), baseline)
+// network is a (staged) SPN searched by dfs, with its compiled form, its
+// fingerprint in the transposition table, and the mapping of its variables
+// to those of the network of the search.
+type network struct {
+	SPN
+	flat *Flat
+	tag  uint64
+	m    VarMap
+}
+
+func (s *search) network(spn SPN) *network {
+	m := VarMap{Orig: indexVals(len(spn.Schema)), Full: freeX(len(spn.Schema))}
+	return &network{spn, Compile(spn), s.memo.fingerprint(spn), m}
+}
+
+// reduce stages net to the variables that are -1 in x, with the others
+// observed.
+func (s *search) reduce(net *network, x []int) *network {
+	red, m := Reduce(net.SPN, x)
+	orig := make([]int, len(m.Orig))
+	for i, k := range m.Orig {
+		orig[i] = net.m.Orig[k]
+	}
+	return &network{red, Compile(red), s.memo.fingerprint(red), VarMap{orig, net.m.Lift(m.Full)}}
+}
+
+// improve records the complete assignment x of net as the best one if its
+// value p is above baseline, and returns the larger of both.
+func (s *search) improve(net *network, x []int, p float64, baseline float64) float64 {
+	if p <= baseline {
 		return baseline
 	}
-	return math.Max(baseline, math.Max(d[0][0], d[0][1]))
+	s.best = net.m.Lift(x)
+	return p
 }
 
-// Exact solver with Forward Checking + Ordering + Stage
-func ExactFCnOnS(spn SPN, baseline float64, timeout int) float64 {
-	ctx, _ := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	x := make([]int, len(spn.Schema))
-	for i := range x {
-		x[i] = -1
+// dfs returns the larger of baseline and the MAP value of net under x, given
+// an upper bound ub on it, NaN if none is known.
+func (s *search) dfs(net *network, x []int, ub float64, baseline float64) float64 {
+	if s.memo == nil {
+		return s.expand(net, x, ub, baseline)
+	}
+	k := s.memo.key(net.tag, x)
+	if e, ok := s.memo.table[k]; ok {
+		if e.exact {
+			return math.Max(baseline, e.value)
+		}
+		if e.value <= baseline {
+			return baseline
+		}
+	}
+	r := s.expand(net, x, ub, baseline)
+	if !s.aborted {
+		// a value not above baseline only proves baseline is an upper bound
+		s.memo.put(k, memoEntry{value: r, exact: r > baseline})
 	}
-	return dfsFCnOnS(ctx, spn, x, baseline)
+	return r
 }
 
-func dfsFCnOnS(ctx context.Context, spn SPN, x []int, baseline float64) float64 {
+func (s *search) expand(net *network, x []int, ub float64, baseline float64) float64 {
 	select {
-	case <-ctx.Done():
-		return baseline
+	case <-s.ctx.Done():
+		return s.leave(net, x, ub, baseline)
 	default:
 	}
+	if s.budget == 0 {
+		return s.leave(net, x, ub, baseline)
+	}
+	if s.budget > 0 {
+		s.budget--
+	}
 
 	x2 := make([]int, len(x))
 	copy(x2, x)
@@ -227,18 +205,30 @@ func dfsFCnOnS(ctx context.Context, spn SPN, x []int, baseline float64) float64
 	var d [][]float64
 	for {
 		updated := false
-		d = derivativeOfAssignmentX(spn, x)
+		d = net.flat.derivativeOfAssignmentX(x)
+		if !s.fc {
+			if marginalOfDerivative(x, d) <= baseline {
+				return baseline
+			}
+			break
+		}
 		for i := range x {
 			if x[i] == -1 {
-				if d[i][0] <= baseline && d[i][1] <= baseline {
-					return baseline
+				live := -1
+				for v := range d[i] {
+					if d[i][v] > baseline {
+						if live != -1 {
+							live = -2
+							break
+						}
+						live = v
+					}
 				}
-				if d[i][0] <= baseline {
-					x[i] = 1
-					updated = true
+				if live == -1 {
+					return baseline
 				}
-				if d[i][1] <= baseline {
-					x[i] = 0
+				if live >= 0 {
+					x[i] = live
 					updated = true
 				}
 			}
@@ -253,114 +243,57 @@ func dfsFCnOnS(ctx context.Context, spn SPN, x []int, baseline float64) float64
 			cnt++
 		}
 	}
-	if cnt > 1 && len(x)-cnt >= 5 {
-		spn = stage(spn, x)
-		x = make([]int, len(spn.Schema))
-		for i := range x {
-			x[i] = -1
-		}
-		d = derivativeOfAssignmentX(spn, x)
+	if cnt == 0 {
+		return s.improve(net, x, marginalOfDerivative(x, d), baseline)
 	}
-	varID := -1
-	valID := -1
-	for i := range x {
-		if x[i] == -1 {
-			var valI int
-			if d[i][0] < d[i][1] {
-				valI = 1
-			} else {
-				valI = 0
-			}
-			if varID == -1 || d[varID][valID] < d[i][valI] {
-				varID = i
-				valID = valI
+	if s.stage && cnt > 1 && len(x)-cnt >= 5 {
+		net = s.reduce(net, x)
+		x = freeX(len(net.Schema))
+		d = net.flat.derivativeOfAssignmentX(x)
+	}
+	varID, vals := s.order(net.SPN, x, d, baseline)
+	if cnt == 1 {
+		for v := range d[varID] {
+			if d[varID][v] == maxF(d[varID]) {
+				x[varID] = v
+				break
 			}
 		}
+		return s.improve(net, x, d[varID][x[varID]], baseline)
 	}
-	if varID == -1 {
-		return math.Max(baseline, d[0][x[0]])
-	}
-	if cnt == 1 {
-		return d[varID][valID]
+	for _, v := range vals {
+		if d[varID][v] <= baseline {
+			continue
+		}
+		if s.aborted {
+			// bounded by its marginal
+			s.open = math.Max(s.open, d[varID][v])
+			continue
+		}
+		x[varID] = v
+		baseline = math.Max(baseline, s.dfs(net, x, d[varID][v], baseline))
 	}
-	x[varID] = valID
-	baseline = dfsFCnOnS(ctx, spn, x, baseline)
-	x[varID] = 1 - valID
-	baseline = dfsFCnOnS(ctx, spn, x, baseline)
 	return baseline
 }
 
-func stage(spn SPN, q []int) SPN {
-	idMap := map[int]int{}
-	varCnt := 0
-	schema := make([]int, 0, len(spn.Schema))
-	for i, c := range q {
-		if c == -1 {
-			idMap[i] = varCnt
-			varCnt++
-			schema = append(schema, spn.Schema[i])
-		}
-	}
-	if varCnt == 0 {
-		log.Println(q)
-		log.Fatal("q has no -1")
-	}
-	nn := len(spn.Nodes)
-	ns := make([]Node, nn+1)
-	we := make([]float64, nn)
-	for i, n := range spn.Nodes {
-		switch n := n.(type) {
-		case *Trm:
-			if q[n.Kth] == -1 {
-				ns[i] = &Trm{Kth: idMap[n.Kth], Value: n.Value}
-			} else {
-				w := math.Inf(-1)
-				if n.Value == 0 && q[n.Kth] == 0 {
-					w = 0
-				}
-				if n.Value == 1 && q[n.Kth] == 1 {
-					w = 0
-				}
-				we[i] = w
-			}
-		case *Sum:
-			if ns[n.Edges[0].Node.ID()] == nil {
-				we[i] = logSumExpF(len(n.Edges), func(k int) float64 {
-					return n.Edges[k].Weight + we[n.Edges[k].Node.ID()]
-				})
-			} else {
-				es := make([]SumEdge, len(n.Edges))
-				for j, e := range n.Edges {
-					es[j] = SumEdge{e.Weight + we[e.Node.ID()], ns[e.Node.ID()]}
-				}
-				ns[i] = &Sum{Edges: es}
-			}
-		case *Prd:
-			es := make([]PrdEdge, 0, len(n.Edges))
-			w := 0.0
-			for _, e := range n.Edges {
-				w += we[e.Node.ID()]
-				if ns[e.Node.ID()] != nil {
-					es = append(es, PrdEdge{ns[e.Node.ID()]})
-				}
-			}
-			we[i] = w
-			if len(es) > 0 {
-				ns[i] = &Prd{Edges: es}
-			}
-		}
+func freeX(n int) []int {
+	x := make([]int, n)
+	for i := range x {
+		x[i] = -1
 	}
-	if _, ok := ns[nn-1].(*Sum); !ok {
-		ns[nn] = &Sum{Edges: []SumEdge{{we[nn-1], ns[nn-1]}}}
+	return x
+}
+
+// marginalOfDerivative is the value of the partial assignment x, recovered
+// from the derivatives d of x.
+func marginalOfDerivative(x []int, d [][]float64) float64 {
+	if len(x) == 0 {
+		return math.Inf(-1)
 	}
-	nodes := make([]Node, 0, nn+1)
-	for _, n := range ns {
-		if n != nil {
-			n.SetID(len(nodes))
-			nodes = append(nodes, n)
-		}
+	if x[0] != -1 {
+		return d[0][x[0]]
 	}
-	return SPN{nodes, schema}
+	return logSumExp(d[0]...)
 }
 
 func evalUncompletedX(spn SPN, x []int, xi int) float64 {
@@ -376,24 +309,3 @@ func evalUncompletedX(spn SPN, x []int, xi int) float64 {
 	}
 	return spn.Eval(a)[len(spn.Nodes)-1]
 }
-
-func derivativeOfAssignmentX(spn SPN, x []int) [][]float64 {
-	return derivativeOfAssignment(spn, X2Ass(x, spn.Schema))
-}
-
-func derivativeOfAssignment(spn SPN, ass [][]float64) [][]float64 {
-	der := spn.Derivative(ass)
-	d := make([][]float64, len(spn.Schema))
-	for i := range d {
-		d[i] = make([]float64, spn.Schema[i])
-		for j := range d[i] {
-			d[i][j] = math.Inf(-1)
-		}
-	}
-	for i, n := range spn.Nodes {
-		if n, ok := n.(*Trm); ok {
-			d[n.Kth][n.Value] = logSumExp(d[n.Kth][n.Value], der[i])
-		}
-	}
-	return d
-}
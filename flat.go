@@ -0,0 +1,210 @@
+package maxspn
+
+import "math"
+
+var ( // Main API
+	_ = Compile
+)
+
+type Kind uint8
+
+const (
+	KindTrm Kind = iota
+	KindSum
+	KindPrd
+)
+
+// Flat is a compiled SPN with nodes in the same topological order, stored in
+// flat arrays instead of interface values. The children of node i are
+// Child[Start[i]:Start[i+1]] with weights Weight[Start[i]:Start[i+1]] (0 for
+// products). Kth and Value describe leaves and are -1 for other nodes.
+//
+// The exact search, ExactAStar, UpperBound, Incremental and the weight
+// learners run on Flat. The SPN methods and the approximate solvers keep the
+// pointer form, as compiling costs about as much as one of their passes.
+type Flat struct {
+	Schema []int
+	Kind   []Kind
+	Start  []int32
+	Child  []int32
+	Weight []float64
+	Kth    []int32
+	Value  []int32
+}
+
+func Compile(spn SPN) *Flat {
+	nn := len(spn.Nodes)
+	f := &Flat{
+		Schema: spn.Schema,
+		Kind:   make([]Kind, nn),
+		Start:  make([]int32, nn+1),
+		Kth:    make([]int32, nn),
+		Value:  make([]int32, nn),
+	}
+	for i, n := range spn.Nodes {
+		f.Kth[i], f.Value[i] = -1, -1
+		switch n := n.(type) {
+		case *Trm:
+			f.Kind[i] = KindTrm
+			f.Kth[i], f.Value[i] = int32(n.Kth), int32(n.Value)
+		case *Sum:
+			f.Kind[i] = KindSum
+			for _, e := range n.Edges {
+				f.Child = append(f.Child, int32(e.Node.ID()))
+				f.Weight = append(f.Weight, e.Weight)
+			}
+		case *Prd:
+			f.Kind[i] = KindPrd
+			for _, e := range n.Edges {
+				f.Child = append(f.Child, int32(e.Node.ID()))
+				f.Weight = append(f.Weight, 0)
+			}
+		}
+		f.Start[i+1] = int32(len(f.Child))
+	}
+	return f
+}
+
+// SPN converts f back to the pointer representation.
+func (f *Flat) SPN() SPN {
+	nodes := make([]Node, len(f.Kind))
+	for i, k := range f.Kind {
+		cs := f.Child[f.Start[i]:f.Start[i+1]]
+		switch k {
+		case KindTrm:
+			nodes[i] = &Trm{Kth: int(f.Kth[i]), Value: int(f.Value[i]), id: i}
+		case KindSum:
+			es := make([]SumEdge, len(cs))
+			for j, c := range cs {
+				es[j] = SumEdge{f.Weight[int(f.Start[i])+j], nodes[c]}
+			}
+			nodes[i] = &Sum{Edges: es, id: i}
+		case KindPrd:
+			es := make([]PrdEdge, len(cs))
+			for j, c := range cs {
+				es[j] = PrdEdge{nodes[c]}
+			}
+			nodes[i] = &Prd{Edges: es, id: i}
+		}
+	}
+	return SPN{nodes, f.Schema}
+}
+
+func (f *Flat) EvalX(x []int) float64 {
+	val := f.Eval(X2Ass(x, f.Schema))
+	return val[len(val)-1]
+}
+
+func (f *Flat) Eval(ass [][]float64) []float64 {
+	val := make([]float64, len(f.Kind))
+	for i, k := range f.Kind {
+		lo, hi := f.Start[i], f.Start[i+1]
+		switch k {
+		case KindTrm:
+			val[i] = math.Log(ass[f.Kth[i]][f.Value[i]])
+		case KindSum:
+			max := math.Inf(-1)
+			for j := lo; j < hi; j++ {
+				max = math.Max(max, f.Weight[j]+val[f.Child[j]])
+			}
+			if math.IsInf(max, 0) {
+				val[i] = max
+				break
+			}
+			sum := 0.0
+			for j := lo; j < hi; j++ {
+				sum += math.Exp(f.Weight[j] + val[f.Child[j]] - max)
+			}
+			val[i] = math.Log(sum) + max
+		case KindPrd:
+			prd := 0.0
+			for j := lo; j < hi; j++ {
+				prd += val[f.Child[j]]
+			}
+			val[i] = prd
+		}
+	}
+	return val
+}
+
+func (f *Flat) DerivativeX(x []int) []float64 {
+	return f.Derivative(X2Ass(x, f.Schema))
+}
+
+// Derivative is SPN.Derivative on the compiled form.
+func (f *Flat) Derivative(ass [][]float64) []float64 {
+	return f.derivative(f.Eval(ass))
+}
+
+// derivative is the downward pass of Derivative given the node values pr.
+func (f *Flat) derivative(pr []float64) []float64 {
+	dr := make([]float64, len(f.Kind))
+	for i := range dr {
+		dr[i] = math.Inf(-1)
+	}
+	dr[len(dr)-1] = 0.0
+	for i := len(f.Kind) - 1; i >= 0; i-- {
+		lo, hi := f.Start[i], f.Start[i+1]
+		switch f.Kind[i] {
+		case KindSum:
+			for j := lo; j < hi; j++ {
+				c := f.Child[j]
+				dr[c] = logSumExp(dr[c], dr[i]+f.Weight[j])
+			}
+		case KindPrd:
+			zeroCnt := 0
+			other := 0.0 // product of the non-zero children
+			for j := lo; j < hi; j++ {
+				if math.IsInf(pr[f.Child[j]], -1) {
+					zeroCnt++
+				} else {
+					other += pr[f.Child[j]]
+				}
+			}
+			for j := lo; j < hi; j++ {
+				c := f.Child[j]
+				var o float64
+				if zeroCnt == 0 {
+					o = pr[i] - pr[c]
+				} else if zeroCnt == 1 && math.IsInf(pr[c], -1) {
+					o = other
+				} else {
+					o = math.Inf(-1)
+				}
+				dr[c] = logSumExp(dr[c], dr[i]+o)
+			}
+		}
+	}
+	return dr
+}
+
+// Partition is the value of every node with all variables marginalized.
+func (f *Flat) Partition() []float64 {
+	ass := make([][]float64, len(f.Schema))
+	for i := range ass {
+		ass[i] = make([]float64, f.Schema[i])
+		for j := range ass[i] {
+			ass[i][j] = 1
+		}
+	}
+	return f.Eval(ass)
+}
+
+// derivativeOfAssignmentX is the derivative of x with respect to every
+// indicator, i.e. the value of x with X_k = v for every variable k and value v.
+func (f *Flat) derivativeOfAssignmentX(x []int) [][]float64 {
+	der := f.DerivativeX(x)
+	d := make([][]float64, len(f.Schema))
+	for i := range d {
+		d[i] = make([]float64, f.Schema[i])
+		for j := range d[i] {
+			d[i][j] = math.Inf(-1)
+		}
+	}
+	for i, k := range f.Kind {
+		if k == KindTrm {
+			d[f.Kth[i]][f.Value[i]] = logSumExp(d[f.Kth[i]][f.Value[i]], der[i])
+		}
+	}
+	return d
+}
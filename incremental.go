@@ -0,0 +1,128 @@
+package maxspn
+
+import (
+	"container/heap"
+	"math"
+)
+
+var ( // Main API
+	_ = NewIncremental
+)
+
+// Incremental keeps the node values of a current assignment, so that
+// changing one variable only re-evaluates the ancestors of its leaves whose
+// values actually change.
+type Incremental struct {
+	flat    *Flat
+	parents [][]int32
+	leaves  [][]int32 // leaves of each variable
+	x       []int
+	val     []float64
+	dirty   []bool
+	fringe  intHeap
+}
+
+// NewIncremental starts from the assignment x, -1 for marginalized variables.
+func NewIncremental(spn SPN, x []int) *Incremental {
+	f := Compile(spn)
+	inc := &Incremental{
+		flat:    f,
+		parents: make([][]int32, len(f.Kind)),
+		leaves:  make([][]int32, len(f.Schema)),
+		x:       make([]int, len(x)),
+		dirty:   make([]bool, len(f.Kind)),
+	}
+	copy(inc.x, x)
+	for i, k := range f.Kind {
+		if k == KindTrm {
+			inc.leaves[f.Kth[i]] = append(inc.leaves[f.Kth[i]], int32(i))
+		}
+		for _, c := range f.Child[f.Start[i]:f.Start[i+1]] {
+			inc.parents[c] = append(inc.parents[c], int32(i))
+		}
+	}
+	inc.val = f.Eval(X2Ass(inc.x, f.Schema))
+	return inc
+}
+
+// Value is the log value of the current assignment.
+func (inc *Incremental) Value() float64 {
+	return inc.val[len(inc.val)-1]
+}
+
+// X is the current assignment. It must not be modified.
+func (inc *Incremental) X() []int {
+	return inc.x
+}
+
+// Set changes variable k to v (-1 to marginalize it) and returns the new
+// value of the assignment.
+func (inc *Incremental) Set(k, v int) float64 {
+	if inc.x[k] == v {
+		return inc.Value()
+	}
+	inc.x[k] = v
+	for _, l := range inc.leaves[k] {
+		inc.push(l)
+	}
+	f := inc.flat
+	for inc.fringe.Len() > 0 {
+		i := heap.Pop(&inc.fringe).(int32)
+		inc.dirty[i] = false
+		old := inc.val[i]
+		lo, hi := f.Start[i], f.Start[i+1]
+		switch f.Kind[i] {
+		case KindTrm:
+			if x := inc.x[f.Kth[i]]; x == -1 || x == int(f.Value[i]) {
+				inc.val[i] = 0
+			} else {
+				inc.val[i] = math.Inf(-1)
+			}
+		case KindSum:
+			inc.val[i] = logSumExpF(int(hi-lo), func(j int) float64 {
+				return f.Weight[int(lo)+j] + inc.val[f.Child[int(lo)+j]]
+			})
+		case KindPrd:
+			prd := 0.0
+			for j := lo; j < hi; j++ {
+				prd += inc.val[f.Child[j]]
+			}
+			inc.val[i] = prd
+		}
+		if inc.val[i] != old {
+			for _, p := range inc.parents[i] {
+				inc.push(p)
+			}
+		}
+	}
+	return inc.Value()
+}
+
+// Derivative is SPN.Derivative of the current assignment, reusing the cached
+// node values instead of an upward pass.
+func (inc *Incremental) Derivative() []float64 {
+	return inc.flat.derivative(inc.val)
+}
+
+func (inc *Incremental) push(i int32) {
+	if !inc.dirty[i] {
+		inc.dirty[i] = true
+		heap.Push(&inc.fringe, i)
+	}
+}
+
+type intHeap []int32
+
+func (h intHeap) Len() int           { return len(h) }
+func (h intHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h intHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *intHeap) Push(x interface{}) {
+	*h = append(*h, x.(int32))
+}
+
+func (h *intHeap) Pop() interface{} {
+	n := len(*h)
+	r := (*h)[n-1]
+	*h = (*h)[0 : n-1]
+	return r
+}
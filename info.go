@@ -0,0 +1,150 @@
+package maxspn
+
+import (
+	"math"
+	"math/rand"
+	"reflect"
+)
+
+var ( // Main API
+	_ = Entropy
+	_ = CrossEntropy
+	_ = KL
+)
+
+// Estimate is a value in nats with its 95% confidence interval, which is
+// [Value, Value] when the value is exact.
+type Estimate struct {
+	Value float64
+	Lo    float64
+	Hi    float64
+	Exact bool
+}
+
+// Entropy of the distribution of spn. It is exact on selective SPNs, and
+// otherwise estimated from n samples drawn with r.
+func Entropy(spn SPN, n int, r *rand.Rand) Estimate {
+	if IsSelective(spn) {
+		h := selectiveEntropy(spn)
+		return Estimate{h, h, h, true}
+	}
+	xs := Sample(spn, nil, n, r)
+	ps := NewBatch(spn).EvalX(xs)
+	z := Partition(spn)
+	for i := range ps {
+		ps[i] = z - ps[i]
+	}
+	return meanEstimate(ps)
+}
+
+// selectiveEntropy uses that the entropy of a product is the sum of the
+// entropies of its children, and that a sum of children with disjoint
+// supports, with mixture weights pi, has entropy sum_c pi_c (H_c - log pi_c).
+func selectiveEntropy(spn SPN) float64 {
+	prt := partition(spn)
+	h := make([]float64, len(spn.Nodes))
+	for i, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Sum:
+			for _, e := range n.Edges {
+				lpi := e.Weight + prt[e.Node.ID()] - prt[i]
+				if !math.IsInf(lpi, -1) {
+					h[i] += math.Exp(lpi) * (h[e.Node.ID()] - lpi)
+				}
+			}
+		case *Prd:
+			for _, e := range n.Edges {
+				h[i] += h[e.Node.ID()]
+			}
+		}
+	}
+	return h[len(h)-1]
+}
+
+// CrossEntropy is the average negative log-likelihood of the assignments xs,
+// -1 for missing values. It is +Inf if some assignment has probability 0.
+func CrossEntropy(spn SPN, xs [][]int) float64 {
+	sum := 0.0
+	for _, p := range NewBatch(spn).EvalX(xs) {
+		sum -= p
+	}
+	return sum/float64(len(xs)) + Partition(spn)
+}
+
+// KL is the divergence KL(p || q) of two SPNs sharing a Schema. It is exact
+// when p is selective and q has the same structure as p, possibly with other
+// weights, see selectiveKL. Otherwise it is estimated from n samples of p
+// drawn with r.
+func KL(p, q SPN, n int, r *rand.Rand) Estimate {
+	fp, fq := Compile(p), Compile(q)
+	if sameStructure(fp, fq) && IsSelective(p) {
+		kl := selectiveKL(fp, fq)
+		return Estimate{kl, kl, kl, true}
+	}
+	xs := Sample(p, nil, n, r)
+	lp := NewBatch(p).EvalX(xs)
+	lq := NewBatch(q).EvalX(xs)
+	z := Partition(q) - Partition(p)
+	for i := range lp {
+		lp[i] += z - lq[i]
+	}
+	return meanEstimate(lp)
+}
+
+// selectiveKL uses that every assignment has a single induced tree, which is
+// the same in p and q, and whose probability is the product of the locally
+// normalized weights of its sum edges. The divergence is then the sum over
+// the sum nodes of their probability of being in the tree of p times the
+// divergence of their normalized weights in p and q.
+func selectiveKL(p, q *Flat) float64 {
+	pp, pq := p.Partition(), q.Partition()
+	root := len(pp) - 1
+	dr := p.derivative(pp)
+	kl := 0.0
+	for i, k := range p.Kind {
+		reach := dr[i] + pp[i] - pp[root]
+		if k != KindSum || math.IsInf(reach, -1) {
+			continue
+		}
+		for j := p.Start[i]; j < p.Start[i+1]; j++ {
+			lp := p.Weight[j] + pp[p.Child[j]] - pp[i]
+			if math.IsInf(lp, -1) {
+				continue
+			}
+			lq := math.Inf(-1)
+			if !math.IsInf(pq[i], -1) {
+				lq = q.Weight[j] + pq[q.Child[j]] - pq[i]
+			}
+			kl += math.Exp(reach+lp) * (lp - lq)
+		}
+	}
+	return kl
+}
+
+// sameStructure reports whether a and b have the same nodes and edges.
+func sameStructure(a, b *Flat) bool {
+	return reflect.DeepEqual(a.Schema, b.Schema) &&
+		reflect.DeepEqual(a.Kind, b.Kind) &&
+		reflect.DeepEqual(a.Start, b.Start) &&
+		reflect.DeepEqual(a.Child, b.Child) &&
+		reflect.DeepEqual(a.Kth, b.Kth) &&
+		reflect.DeepEqual(a.Value, b.Value)
+}
+
+func meanEstimate(vs []float64) Estimate {
+	n := float64(len(vs))
+	mean := 0.0
+	for _, v := range vs {
+		mean += v
+	}
+	mean /= n
+	if math.IsInf(mean, 0) || math.IsNaN(mean) || len(vs) < 2 {
+		return Estimate{mean, math.Inf(-1), math.Inf(1), false}
+	}
+	vr := 0.0
+	for _, v := range vs {
+		vr += (v - mean) * (v - mean)
+	}
+	hw := 1.96 * math.Sqrt(vr/(n-1)/n)
+	return Estimate{mean, mean - hw, mean + hw, false}
+}
@@ -0,0 +1,295 @@
+package maxspn
+
+import (
+	"math"
+	"math/rand"
+)
+
+var ( // Main API
+	_ = LearnSPN
+)
+
+// LearnSPN learns the structure and weights of an SPN from the assignments xs
+// over schema (-1 for missing values, ignored in counts), LearnSPN-style:
+// variables are split into products by pairwise G-tests of independence at
+// significance pvalue, rows into sums by 2-means clustering under Hamming
+// distance, and scopes with fewer than minRows rows are fully factorized.
+// Leaf distributions are Laplace smoothed. Nodes are in topological order.
+func LearnSPN(xs [][]int, schema []int, minRows int, pvalue float64, r *rand.Rand) SPN {
+	l := &learner{
+		schema: schema,
+		pvalue: pvalue,
+		minRow: minRows,
+		r:      r,
+		trm:    make([][]Node, len(schema)),
+	}
+	vars := make([]int, len(schema))
+	for i := range vars {
+		vars[i] = i
+	}
+	l.learn(xs, vars)
+	return SPN{l.nodes, schema}
+}
+
+type learner struct {
+	schema []int
+	pvalue float64
+	minRow int
+	r      *rand.Rand
+	nodes  []Node
+	trm    [][]Node // shared indicator leaves
+}
+
+func (l *learner) add(n Node) Node {
+	n.SetID(len(l.nodes))
+	l.nodes = append(l.nodes, n)
+	return n
+}
+
+// learn builds a node over vars from rows.
+func (l *learner) learn(rows [][]int, vars []int) Node {
+	if len(vars) == 1 {
+		return l.leaf(rows, vars[0])
+	}
+	if len(rows) < l.minRow || len(rows) < 2 {
+		return l.factorize(rows, vars)
+	}
+	if comps := l.components(rows, vars); len(comps) > 1 {
+		es := make([]PrdEdge, len(comps))
+		for i, c := range comps {
+			es[i] = PrdEdge{l.learn(rows, c)}
+		}
+		return l.add(&Prd{Edges: es})
+	}
+	cls := l.cluster(rows, vars)
+	if len(cls) < 2 {
+		return l.factorize(rows, vars)
+	}
+	es := make([]SumEdge, len(cls))
+	for i, c := range cls {
+		es[i] = SumEdge{math.Log(float64(len(c)) / float64(len(rows))), l.learn(c, vars)}
+	}
+	return l.add(&Sum{Edges: es})
+}
+
+func (l *learner) factorize(rows [][]int, vars []int) Node {
+	es := make([]PrdEdge, len(vars))
+	for i, k := range vars {
+		es[i] = PrdEdge{l.leaf(rows, k)}
+	}
+	return l.add(&Prd{Edges: es})
+}
+
+// leaf is the smoothed distribution of variable k over its indicators.
+func (l *learner) leaf(rows [][]int, k int) Node {
+	if l.trm[k] == nil {
+		l.trm[k] = make([]Node, l.schema[k])
+		for v := range l.trm[k] {
+			l.trm[k][v] = l.add(&Trm{Kth: k, Value: v})
+		}
+	}
+	cnt := make([]float64, l.schema[k])
+	tot := 0.0
+	for _, x := range rows {
+		if x[k] != -1 {
+			cnt[x[k]]++
+			tot++
+		}
+	}
+	es := make([]SumEdge, l.schema[k])
+	for v := range es {
+		es[v] = SumEdge{math.Log((cnt[v] + 1) / (tot + float64(l.schema[k]))), l.trm[k][v]}
+	}
+	return l.add(&Sum{Edges: es})
+}
+
+// components groups vars into connected components of the graph whose
+// edges are the pairs found dependent by the G-test.
+func (l *learner) components(rows [][]int, vars []int) [][]int {
+	parent := make([]int, len(vars))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+	for a := range vars {
+		for b := a + 1; b < len(vars); b++ {
+			if find(a) != find(b) && !l.independent(rows, vars[a], vars[b]) {
+				parent[find(a)] = find(b)
+			}
+		}
+	}
+	idx := map[int]int{}
+	var comps [][]int
+	for i, k := range vars {
+		root := find(i)
+		if _, ok := idx[root]; !ok {
+			idx[root] = len(comps)
+			comps = append(comps, nil)
+		}
+		comps[idx[root]] = append(comps[idx[root]], k)
+	}
+	return comps
+}
+
+// independent is the G-test of independence of variables a and b in rows.
+func (l *learner) independent(rows [][]int, a, b int) bool {
+	na, nb := l.schema[a], l.schema[b]
+	joint := make([]float64, na*nb)
+	ma := make([]float64, na)
+	mb := make([]float64, nb)
+	n := 0.0
+	for _, x := range rows {
+		if x[a] != -1 && x[b] != -1 {
+			joint[x[a]*nb+x[b]]++
+			ma[x[a]]++
+			mb[x[b]]++
+			n++
+		}
+	}
+	g := 0.0
+	dfa, dfb := -1, -1
+	for _, c := range ma {
+		if c > 0 {
+			dfa++
+		}
+	}
+	for _, c := range mb {
+		if c > 0 {
+			dfb++
+		}
+	}
+	if dfa <= 0 || dfb <= 0 {
+		return true
+	}
+	for i := 0; i < na; i++ {
+		for j := 0; j < nb; j++ {
+			if o := joint[i*nb+j]; o > 0 {
+				g += 2 * o * math.Log(o*n/(ma[i]*mb[j]))
+			}
+		}
+	}
+	return chi2Sf(g, float64(dfa*dfb)) > l.pvalue
+}
+
+// cluster splits rows in two by k-means under Hamming distance on vars. It
+// returns fewer than two clusters if the split is degenerate.
+func (l *learner) cluster(rows [][]int, vars []int) [][][]int {
+	const k, iters = 2, 10
+	centers := make([][]int, k)
+	for c, i := range l.r.Perm(len(rows))[:k] {
+		centers[c] = rows[i]
+	}
+	assign := make([]int, len(rows))
+	for it := 0; it < iters; it++ {
+		changed := it == 0
+		for i, x := range rows {
+			best, bestD := 0, -1
+			for c := range centers {
+				d := 0
+				for _, v := range vars {
+					if x[v] != centers[c][v] {
+						d++
+					}
+				}
+				if bestD == -1 || d < bestD {
+					best, bestD = c, d
+				}
+			}
+			if assign[i] != best {
+				assign[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		centers = l.modes(rows, vars, assign, k)
+	}
+	cls := make([][][]int, k)
+	for i, x := range rows {
+		cls[assign[i]] = append(cls[assign[i]], x)
+	}
+	var res [][][]int
+	for _, c := range cls {
+		if len(c) > 0 {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// modes is the most frequent value of every variable in every cluster.
+func (l *learner) modes(rows [][]int, vars []int, assign []int, k int) [][]int {
+	centers := make([][]int, k)
+	for c := range centers {
+		centers[c] = make([]int, len(l.schema))
+		for _, v := range vars {
+			cnt := make([]int, l.schema[v])
+			for i, x := range rows {
+				if assign[i] == c && x[v] != -1 {
+					cnt[x[v]]++
+				}
+			}
+			for val := range cnt {
+				if cnt[val] > cnt[centers[c][v]] {
+					centers[c][v] = val
+				}
+			}
+		}
+	}
+	return centers
+}
+
+// chi2Sf is the survival function of the chi-square distribution with df
+// degrees of freedom, i.e. the regularized upper incomplete gamma function
+// Q(df/2, x/2).
+func chi2Sf(x, df float64) float64 {
+	a, x := df/2, x/2
+	if x <= 0 {
+		return 1
+	}
+	lg, _ := math.Lgamma(a)
+	if x < a+1 {
+		// series of the lower function P
+		sum, del := 1/a, 1/a
+		for n := 1.0; n < 500; n++ {
+			del *= x / (a + n)
+			sum += del
+			if math.Abs(del) < math.Abs(sum)*1e-14 {
+				break
+			}
+		}
+		return 1 - sum*math.Exp(-x+a*math.Log(x)-lg)
+	}
+	// continued fraction of Q by the modified Lentz method
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1.0; i < 500; i++ {
+		an := -i * (i - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		del := d * c
+		h *= del
+		if math.Abs(del-1) < 1e-14 {
+			break
+		}
+	}
+	return math.Exp(-x+a*math.Log(x)-lg) * h
+}
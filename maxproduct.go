@@ -0,0 +1,102 @@
+package maxspn
+
+import "math"
+
+// MaxEvalX is MaxEval of the assignment x, -1 for marginalized variables.
+func (spn SPN) MaxEvalX(x []int) []float64 {
+	return spn.MaxEval(X2Ass(x, spn.Schema))
+}
+
+// MaxEval is Eval with sums replaced by maxima: the value of each node is the
+// value of its best induced tree.
+func (spn SPN) MaxEval(ass [][]float64) []float64 {
+	val := make([]float64, len(spn.Nodes))
+	for _, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Trm:
+			val[n.ID()] = math.Log(ass[n.Kth][n.Value])
+		case *Sum:
+			max := math.Inf(-1)
+			for _, e := range n.Edges {
+				max = math.Max(max, e.Weight+val[e.Node.ID()])
+			}
+			val[n.ID()] = max
+		case *Prd:
+			prd := 0.0
+			for _, e := range n.Edges {
+				prd += val[e.Node.ID()]
+			}
+			val[n.ID()] = prd
+		}
+	}
+	return val
+}
+
+// MaxDerivative is Derivative with sums replaced by maxima: dr[i]+val[i] is
+// the value of the best induced tree through node i.
+func (spn SPN) MaxDerivative(ass [][]float64) []float64 {
+	pr := spn.MaxEval(ass)
+	dr := make([]float64, len(spn.Nodes))
+	for i := range dr {
+		dr[i] = math.Inf(-1)
+	}
+	dr[len(dr)-1] = 0.0
+	for i := len(spn.Nodes) - 1; i >= 0; i-- {
+		switch n := spn.Nodes[i].(type) {
+		case *Sum:
+			for _, e := range n.Edges {
+				dr[e.Node.ID()] = math.Max(dr[e.Node.ID()], dr[i]+e.Weight)
+			}
+		case *Prd:
+			zeroCnt := 0
+			other := 0.0
+			for _, e := range n.Edges {
+				if math.IsInf(pr[e.Node.ID()], -1) {
+					zeroCnt++
+				} else {
+					other += pr[e.Node.ID()]
+				}
+			}
+			for _, e := range n.Edges {
+				o := math.Inf(-1)
+				if zeroCnt == 0 {
+					o = pr[i] - pr[e.Node.ID()]
+				} else if zeroCnt == 1 && math.IsInf(pr[e.Node.ID()], -1) {
+					o = other
+				}
+				dr[e.Node.ID()] = math.Max(dr[e.Node.ID()], dr[i]+o)
+			}
+		}
+	}
+	return dr
+}
+
+// MaxTree returns the nodes of the best induced tree given the max values val
+// of MaxEval, taking the first best child of every sum node.
+func (spn SPN) MaxTree(val []float64) []bool {
+	reach := make([]bool, len(spn.Nodes))
+	reach[len(spn.Nodes)-1] = true
+	for i := len(spn.Nodes) - 1; i >= 0; i-- {
+		if reach[i] {
+			switch n := spn.Nodes[i].(type) {
+			case *Sum:
+				eBest, pBest := -1, math.Inf(-1)
+				for _, e := range n.Edges {
+					crt := e.Weight + val[e.Node.ID()]
+					if pBest < crt {
+						pBest = crt
+						eBest = e.Node.ID()
+					}
+				}
+				if eBest != -1 {
+					reach[eBest] = true
+				}
+			case *Prd:
+				for _, e := range n.Edges {
+					reach[e.Node.ID()] = true
+				}
+			}
+		}
+	}
+	return reach
+}
@@ -0,0 +1,85 @@
+package maxspn
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+)
+
+// memo is the transposition table of the exact search. A subproblem is the
+// staged network, identified by a fingerprint of its structure and weights,
+// together with the partial assignment of its variables. Since reduce folds
+// assigned variables into weights, branches whose assignments fold into the
+// same residual network share an entry, as do the runs of ExactRestarts.
+type memo struct {
+	table map[[16]byte]memoEntry
+	cap   int
+}
+
+type memoEntry struct {
+	value float64
+	exact bool // otherwise value is an upper bound
+}
+
+func newMemo(cap int) *memo {
+	if cap <= 0 {
+		return nil
+	}
+	return &memo{table: map[[16]byte]memoEntry{}, cap: cap}
+}
+
+// put stores e unless the table is full. Entries already present are always
+// updated, as a later search of the same subproblem is at least as tight.
+func (m *memo) put(k [16]byte, e memoEntry) {
+	if _, ok := m.table[k]; ok || len(m.table) < m.cap {
+		m.table[k] = e
+	}
+}
+
+func (m *memo) key(tag uint64, x []int) [16]byte {
+	h := fnv.New128a()
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], tag)
+	h.Write(buf[:])
+	for _, xi := range x {
+		binary.LittleEndian.PutUint64(buf[:], uint64(xi))
+		h.Write(buf[:])
+	}
+	var k [16]byte
+	copy(k[:], h.Sum(nil))
+	return k
+}
+
+func (m *memo) fingerprint(spn SPN) uint64 {
+	if m == nil {
+		return 0
+	}
+	h := fnv.New64a()
+	var buf [8]byte
+	write := func(u uint64) {
+		binary.LittleEndian.PutUint64(buf[:], u)
+		h.Write(buf[:])
+	}
+	for _, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Trm:
+			write(0)
+			write(uint64(n.Kth))
+			write(uint64(n.Value))
+		case *Sum:
+			write(1)
+			write(uint64(len(n.Edges)))
+			for _, e := range n.Edges {
+				write(uint64(e.Node.ID()))
+				write(math.Float64bits(e.Weight))
+			}
+		case *Prd:
+			write(2)
+			write(uint64(len(n.Edges)))
+			for _, e := range n.Edges {
+				write(uint64(e.Node.ID()))
+			}
+		}
+	}
+	return h.Sum64()
+}
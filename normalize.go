@@ -0,0 +1,51 @@
+package maxspn
+
+import "math"
+
+var ( // Main API
+	_ = Partition
+	_ = Unnormalized
+	_ = Normalize
+)
+
+// Partition is the log partition function of spn, 0 when EvalX values are
+// log-probabilities.
+func Partition(spn SPN) float64 {
+	return partition(spn)[len(spn.Nodes)-1]
+}
+
+// Unnormalized returns the IDs of the sum nodes whose weights do not sum to 1
+// within tol in log domain.
+func Unnormalized(spn SPN, tol float64) []int {
+	var ids []int
+	for i, n := range spn.Nodes {
+		if n, ok := n.(*Sum); ok {
+			z := logSumExpF(len(n.Edges), func(k int) float64 {
+				return n.Edges[k].Weight
+			})
+			if !(math.Abs(z) <= tol) {
+				ids = append(ids, i)
+			}
+		}
+	}
+	return ids
+}
+
+// Normalize returns a copy of spn with locally normalized sum weights that
+// represents the same distribution, with the log partition function of spn.
+// Every weight w of an edge i->c becomes w + Z_c - Z_i, where Z are the
+// partition values of the nodes, so normalization constants are pushed up
+// to the root and divided out there. Nodes with zero partition are kept.
+func Normalize(spn SPN) (SPN, float64) {
+	f := Compile(spn)
+	prt := f.Partition()
+	for i, k := range f.Kind {
+		if k != KindSum || math.IsInf(prt[i], -1) {
+			continue
+		}
+		for j := f.Start[i]; j < f.Start[i+1]; j++ {
+			f.Weight[j] += prt[f.Child[j]] - prt[i]
+		}
+	}
+	return f.SPN(), prt[len(prt)-1]
+}
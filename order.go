@@ -0,0 +1,127 @@
+package maxspn
+
+import (
+	"math/rand"
+	"sort"
+)
+
+var ( // Main API
+	_ = OrderFirst
+	_ = OrderMaxDerivative
+	_ = OrderMinDomain
+	_ = OrderMostConstrained
+	_ = OrderScopeSize
+	_ = OrderRandom
+)
+
+// Order is a branching strategy of the exact search. Given the partial
+// assignment x of spn (-1 for free variables), the derivatives d of x, and
+// the current baseline, it returns the free variable to branch on and the
+// order in which its values are tried. Values whose derivative is not above
+// baseline are skipped by the search and need not be filtered.
+type Order func(spn SPN, x []int, d [][]float64, baseline float64) (int, []int)
+
+// OrderFirst branches on the first free variable, values in index order.
+func OrderFirst(spn SPN, x []int, d [][]float64, baseline float64) (int, []int) {
+	for i := range x {
+		if x[i] == -1 {
+			return i, indexVals(len(d[i]))
+		}
+	}
+	return -1, nil
+}
+
+// OrderMaxDerivative branches on the free variable with the largest
+// derivative, most promising value first.
+func OrderMaxDerivative(spn SPN, x []int, d [][]float64, baseline float64) (int, []int) {
+	return orderBy(x, d, func(i, j int) bool {
+		return maxF(d[i]) > maxF(d[j])
+	})
+}
+
+// OrderMinDomain branches on the free variable with the fewest values above
+// baseline, breaking ties by OrderMaxDerivative.
+func OrderMinDomain(spn SPN, x []int, d [][]float64, baseline float64) (int, []int) {
+	domain := func(i int) int {
+		cnt := 0
+		for _, v := range d[i] {
+			if v > baseline {
+				cnt++
+			}
+		}
+		return cnt
+	}
+	return orderBy(x, d, func(i, j int) bool {
+		if di, dj := domain(i), domain(j); di != dj {
+			return di < dj
+		}
+		return maxF(d[i]) > maxF(d[j])
+	})
+}
+
+// OrderMostConstrained branches fail-first on the free variable whose best
+// value has the smallest derivative.
+func OrderMostConstrained(spn SPN, x []int, d [][]float64, baseline float64) (int, []int) {
+	return orderBy(x, d, func(i, j int) bool {
+		return maxF(d[i]) < maxF(d[j])
+	})
+}
+
+// OrderScopeSize branches on the free variable with the most indicator
+// leaves, i.e. the one that appears in the scope of most of the network.
+func OrderScopeSize(spn SPN, x []int, d [][]float64, baseline float64) (int, []int) {
+	cnt := make([]int, len(spn.Schema))
+	for _, n := range spn.Nodes {
+		if n, ok := n.(*Trm); ok {
+			cnt[n.Kth]++
+		}
+	}
+	return orderBy(x, d, func(i, j int) bool {
+		return cnt[i] > cnt[j]
+	})
+}
+
+// OrderRandom branches on a random free variable with values in random
+// order. It is meant for ExactRestarts.
+func OrderRandom(r *rand.Rand) Order {
+	return func(spn SPN, x []int, d [][]float64, baseline float64) (int, []int) {
+		var free []int
+		for i := range x {
+			if x[i] == -1 {
+				free = append(free, i)
+			}
+		}
+		if len(free) == 0 {
+			return -1, nil
+		}
+		i := free[r.Intn(len(free))]
+		return i, r.Perm(len(d[i]))
+	}
+}
+
+// orderBy returns the first free variable not beaten by any other, with its
+// values by decreasing derivative.
+func orderBy(x []int, d [][]float64, better func(i, j int) bool) (int, []int) {
+	varID := -1
+	for i := range x {
+		if x[i] == -1 && (varID == -1 || better(i, varID)) {
+			varID = i
+		}
+	}
+	if varID == -1 {
+		return -1, nil
+	}
+	vals := indexVals(len(d[varID]))
+	sort.SliceStable(vals, func(a, b int) bool {
+		return d[varID][vals[a]] > d[varID][vals[b]]
+	})
+	return varID, vals
+}
+
+func indexVals(n int) []int {
+	vals := make([]int, n)
+	for v := range vals {
+		vals[v] = v
+	}
+	return vals
+}
@@ -0,0 +1,50 @@
+package maxspn
+
+import "math"
+
+var ( // Main API
+	_ = Prune
+)
+
+// Prune removes the sum edges of spn whose share of the probability mass is
+// below threshold, renormalizes and garbage-collects the network. The share
+// of an edge i->c is the mass of the induced trees through it, or, if
+// relative, its mass relative to the heaviest edge of node i. The heaviest
+// edge is never removed, so that the network stays non-zero. It also returns
+// an upper bound m on the removed probability mass: for every x the pruned
+// distribution satisfies P(x) - m <= P'(x) <= P(x) / (1 - m), and so does the
+// MAP value.
+func Prune(spn SPN, threshold float64, relative bool) (SPN, float64) {
+	spn, _ = Normalize(spn)
+	f := Compile(spn)
+	prt := f.Partition()
+	dr := f.derivative(prt)
+	lt := math.Log(threshold)
+	mass := 0.0
+	for i, k := range f.Kind {
+		if k != KindSum {
+			continue
+		}
+		lo, hi := f.Start[i], f.Start[i+1]
+		heaviest, max := lo, math.Inf(-1)
+		for j := lo; j < hi; j++ {
+			if w := f.Weight[j] + prt[f.Child[j]]; max < w {
+				heaviest, max = j, w
+			}
+		}
+		for j := lo; j < hi; j++ {
+			share := f.Weight[j] + prt[f.Child[j]]
+			if relative {
+				share -= max
+			} else {
+				share += dr[i]
+			}
+			if share < lt && j != heaviest {
+				mass += math.Exp(dr[i] + f.Weight[j] + prt[f.Child[j]])
+				f.Weight[j] = math.Inf(-1)
+			}
+		}
+	}
+	pruned, _ := Normalize(Simplify(f.SPN()))
+	return pruned, math.Min(mass, 1)
+}
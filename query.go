@@ -0,0 +1,68 @@
+package maxspn
+
+import "math"
+
+var ( // Main API
+	_ = LogProb
+	_ = LogCond
+	_ = Marginals
+)
+
+// Evidence maps variable indices to their observed values.
+type Evidence map[int]int
+
+// X is the assignment of e over schema, -1 for unobserved variables.
+func (e Evidence) X(schema []int) []int {
+	x := make([]int, len(schema))
+	for i := range x {
+		x[i] = -1
+	}
+	for k, v := range e {
+		x[k] = v
+	}
+	return x
+}
+
+// LogProb is log P(e).
+func LogProb(spn SPN, e Evidence) float64 {
+	return spn.EvalX(e.X(spn.Schema))
+}
+
+// LogCond is log P(q | e), -Inf if q and e disagree on a variable.
+func LogCond(spn SPN, q, e Evidence) float64 {
+	qe := Evidence{}
+	for k, v := range e {
+		qe[k] = v
+	}
+	for k, v := range q {
+		if w, ok := qe[k]; ok && w != v {
+			return math.Inf(-1)
+		}
+		qe[k] = v
+	}
+	return LogProb(spn, qe) - LogProb(spn, e)
+}
+
+// Marginals returns log P(X_i = v | e) of every variable i and value v in one
+// upward and downward pass. Observed variables have log probability 0 at
+// their value. All entries are NaN if P(e) is 0.
+func Marginals(spn SPN, e Evidence) [][]float64 {
+	x := e.X(spn.Schema)
+	d := Compile(spn).derivativeOfAssignmentX(x)
+	pe := marginalOfDerivative(x, d)
+	for i := range d {
+		for v := range d[i] {
+			switch {
+			case math.IsInf(pe, -1):
+				d[i][v] = math.NaN()
+			case x[i] == -1:
+				d[i][v] -= pe
+			case x[i] == v:
+				d[i][v] = 0
+			default:
+				d[i][v] = math.Inf(-1)
+			}
+		}
+	}
+	return d
+}
@@ -0,0 +1,213 @@
+package maxspn
+
+import (
+	"log"
+	"math"
+	"sort"
+)
+
+var ( // Main API
+	_ = Reduce
+)
+
+// Values of a reduction query besides observed values.
+const (
+	Query       = -1 // variable kept in the reduced network
+	Marginalize = -2 // variable summed out
+)
+
+// Reduce folds the observed and marginalized variables of q into weights and
+// returns the network over the Query variables, renumbered in order, with
+// their mapping. Its EvalX values are the values of spn on the lifted
+// assignments. Children of a sum over fewer Query variables than the sum,
+// e.g. reduced to constants while their siblings are not, are multiplied by
+// sums of indicators with weight 1 over the missing variables, and so is the
+// root, so the reduced network is complete. At least one variable must be a
+// Query.
+func Reduce(spn SPN, q []int) (SPN, VarMap) {
+	m := VarMap{Full: make([]int, len(q))}
+	idMap := map[int]int{}
+	schema := make([]int, 0, len(spn.Schema))
+	for i, c := range q {
+		m.Full[i] = c
+		if c == Query || c == Marginalize {
+			m.Full[i] = -1
+		}
+		if c == Query {
+			idMap[i] = len(m.Orig)
+			m.Orig = append(m.Orig, i)
+			schema = append(schema, spn.Schema[i])
+		}
+	}
+	if len(m.Orig) == 0 {
+		log.Println(q)
+		log.Fatal("Reduce: no query variable")
+	}
+	r := &reducer{schema: schema, leaves: map[[2]int]Node{}, ones: map[int]Node{}}
+	nn := len(spn.Nodes)
+	ns := make([]Node, nn)    // reduced node, nil for constants
+	we := make([]float64, nn) // constant factor of every node
+	size := make([]int, nn)   // number of Query variables in the scope
+	for i, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Trm:
+			if c := q[n.Kth]; c == Query {
+				ns[i] = r.leaf(idMap[n.Kth], n.Value)
+				size[i] = 1
+			} else if c == Marginalize || c == n.Value {
+				we[i] = 0
+			} else {
+				we[i] = math.Inf(-1)
+			}
+		case *Sum:
+			var cs []Node
+			for _, e := range n.Edges {
+				if c := e.Node.ID(); ns[c] != nil {
+					cs = append(cs, ns[c])
+					if size[i] < size[c] {
+						size[i] = size[c]
+					}
+				}
+			}
+			if cs == nil {
+				we[i] = logSumExpF(len(n.Edges), func(k int) float64 {
+					return n.Edges[k].Weight + we[n.Edges[k].Node.ID()]
+				})
+				continue
+			}
+			var sc []int // scope of the sum, if some child misses variables
+			es := make([]SumEdge, len(n.Edges))
+			for j, e := range n.Edges {
+				c := e.Node.ID()
+				es[j] = SumEdge{e.Weight + we[c], ns[c]}
+				if size[c] < size[i] {
+					if sc == nil {
+						sc = scope(cs)
+					}
+					es[j].Node = r.pad(ns[c], sc)
+				}
+			}
+			ns[i] = r.add(&Sum{Edges: es})
+		case *Prd:
+			es := make([]PrdEdge, 0, len(n.Edges))
+			w := 0.0
+			for _, e := range n.Edges {
+				c := e.Node.ID()
+				w += we[c]
+				if ns[c] != nil {
+					es = append(es, PrdEdge{ns[c]})
+					size[i] += size[c]
+				}
+			}
+			we[i] = w
+			if len(es) > 0 {
+				ns[i] = r.add(&Prd{Edges: es})
+			}
+		}
+	}
+	root := ns[nn-1]
+	_, isSum := root.(*Sum)
+	if size[nn-1] < len(schema) {
+		root = r.pad(root, indexVals(len(schema)))
+	}
+	// the constant factor of a root that is not a sum is kept by a new root,
+	// which also keeps a padded sum root last
+	if !isSum || root != ns[nn-1] {
+		r.add(&Sum{Edges: []SumEdge{{we[nn-1], root}}})
+	}
+	return SPN{r.nodes, schema}, m
+}
+
+type reducer struct {
+	schema []int
+	nodes  []Node
+	leaves map[[2]int]Node // first leaf of every variable and value
+	ones   map[int]Node    // sum of the indicators of every variable
+}
+
+func (r *reducer) add(n Node) Node {
+	n.SetID(len(r.nodes))
+	r.nodes = append(r.nodes, n)
+	return n
+}
+
+// leaf adds a leaf for value v of variable k, shared by the padding nodes.
+func (r *reducer) leaf(k, v int) Node {
+	n := r.add(&Trm{Kth: k, Value: v})
+	if _, ok := r.leaves[[2]int{k, v}]; !ok {
+		r.leaves[[2]int{k, v}] = n
+	}
+	return n
+}
+
+// pad multiplies n, nil for 1, by the sums of indicators of the variables of
+// sc not in its scope.
+func (r *reducer) pad(n Node, sc []int) Node {
+	var es []PrdEdge
+	in := map[int]bool{}
+	if n != nil {
+		es = append(es, PrdEdge{n})
+		for _, k := range scope([]Node{n}) {
+			in[k] = true
+		}
+	}
+	for _, k := range sc {
+		if !in[k] {
+			es = append(es, PrdEdge{r.one(k)})
+		}
+	}
+	if len(es) == 1 {
+		return es[0].Node
+	}
+	return r.add(&Prd{Edges: es})
+}
+
+// one is the sum of the indicators of variable k with weight 1, i.e. 1 for
+// every value of k.
+func (r *reducer) one(k int) Node {
+	if r.ones[k] == nil {
+		es := make([]SumEdge, r.schema[k])
+		for v := range es {
+			l, ok := r.leaves[[2]int{k, v}]
+			if !ok {
+				l = r.leaf(k, v)
+			}
+			es[v] = SumEdge{0, l}
+		}
+		r.ones[k] = r.add(&Sum{Edges: es})
+	}
+	return r.ones[k]
+}
+
+// scope returns the sorted variables of the leaves below ns.
+func scope(ns []Node) []int {
+	ns = append([]Node(nil), ns...)
+	seen := map[Node]bool{}
+	in := map[int]bool{}
+	for len(ns) > 0 {
+		n := ns[len(ns)-1]
+		ns = ns[:len(ns)-1]
+		if seen[n] {
+			continue
+		}
+		seen[n] = true
+		switch n := n.(type) {
+		case *Trm:
+			in[n.Kth] = true
+		case *Sum:
+			for _, e := range n.Edges {
+				ns = append(ns, e.Node)
+			}
+		case *Prd:
+			for _, e := range n.Edges {
+				ns = append(ns, e.Node)
+			}
+		}
+	}
+	vars := make([]int, 0, len(in))
+	for k := range in {
+		vars = append(vars, k)
+	}
+	sort.Ints(vars)
+	return vars
+}
@@ -0,0 +1,293 @@
+package maxspn
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// randomSPN builds a complete and decomposable SPN over schema, with sums of
+// products of random splits of the scope.
+func randomSPN(r *rand.Rand, schema []int) SPN {
+	var nodes []Node
+	add := func(n Node) Node {
+		n.SetID(len(nodes))
+		nodes = append(nodes, n)
+		return n
+	}
+	var build func(sc []int) Node
+	build = func(sc []int) Node {
+		if len(sc) == 1 {
+			es := make([]SumEdge, schema[sc[0]])
+			for v := range es {
+				es[v] = SumEdge{math.Log(r.Float64()), add(&Trm{Kth: sc[0], Value: v})}
+			}
+			return add(&Sum{Edges: es})
+		}
+		es := make([]SumEdge, 2+r.Intn(2))
+		for j := range es {
+			cut := 1 + r.Intn(len(sc)-1)
+			p := r.Perm(len(sc))
+			var a, b []int
+			for i, k := range p {
+				if i < cut {
+					a = append(a, sc[k])
+				} else {
+					b = append(b, sc[k])
+				}
+			}
+			es[j] = SumEdge{math.Log(r.Float64()), add(&Prd{Edges: []PrdEdge{{build(a)}, {build(b)}}})}
+		}
+		return add(&Sum{Edges: es})
+	}
+	build(indexVals(len(schema)))
+	return SPN{nodes, schema}
+}
+
+// assignments enumerates all complete assignments over schema.
+func assignments(schema []int) [][]int {
+	xs := [][]int{{}}
+	for _, n := range schema {
+		var next [][]int
+		for _, x := range xs {
+			for v := 0; v < n; v++ {
+				next = append(next, append(append([]int(nil), x...), v))
+			}
+		}
+		xs = next
+	}
+	return xs
+}
+
+func approxEqual(a, b float64) bool {
+	return a == b || math.Abs(a-b) < 1e-9
+}
+
+// isComplete reports whether the children of every sum have the same scope.
+func isComplete(spn SPN) bool {
+	for _, n := range spn.Nodes {
+		if n, ok := n.(*Sum); ok {
+			sc := scope([]Node{n.Edges[0].Node})
+			for _, e := range n.Edges[1:] {
+				if !reflect.DeepEqual(scope([]Node{e.Node}), sc) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+func checkReduce(t *testing.T, spn, red SPN, m VarMap) {
+	t.Helper()
+	if !isComplete(red) {
+		t.Fatal("reduced network is not complete")
+	}
+	best := math.Inf(-1)
+	for _, x := range assignments(red.Schema) {
+		p := red.EvalX(x)
+		if want := spn.EvalX(m.Lift(x)); !approxEqual(p, want) {
+			t.Fatalf("EvalX(%v) = %v, want %v", x, p, want)
+		}
+		best = math.Max(best, p)
+	}
+	if p := ExactFCnOnS(red, math.Inf(-1), 10); !approxEqual(p, best) {
+		t.Fatalf("ExactFCnOnS = %v, want %v", p, best)
+	}
+	if ub := UpperBound(red, nil); ub < best-1e-9 {
+		t.Fatalf("UpperBound = %v below MAP value %v", ub, best)
+	}
+}
+
+func TestReduce(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for it := 0; it < 50; it++ {
+		schema := make([]int, 6)
+		for i := range schema {
+			schema[i] = 2 + r.Intn(3)
+		}
+		spn := randomSPN(r, schema)
+		q := make([]int, len(schema))
+		bq := make([]byte, len(schema))
+		for i := range q {
+			switch c := r.Intn(4); c {
+			case 0:
+				q[i], bq[i] = Query, '?'
+			case 1:
+				q[i], bq[i] = Marginalize, '*'
+			default:
+				q[i] = r.Intn(schema[i])
+				bq[i] = byte('0' + q[i])
+			}
+		}
+		k := r.Intn(len(q))
+		q[k], bq[k] = Query, '?'
+		red, m := Reduce(spn, q)
+		checkReduce(t, spn, red, m)
+		red = MAP2MAX(spn, bq)
+		checkReduce(t, spn, red, QueryVarMap(bq))
+	}
+}
+
+func TestReduceBaseline(t *testing.T) {
+	r := rand.New(rand.NewSource(2))
+	for it := 0; it < 50; it++ {
+		spn := randomSPN(r, []int{2, 2, 2, 2, 2, 2, 2})
+		q := make([]int, 7)
+		bq := make([]byte, 7)
+		for i := range q {
+			q[i] = r.Intn(3) - 1
+			bq[i] = "?01*"[r.Intn(4)]
+		}
+		q[r.Intn(7)], bq[r.Intn(7)] = -1, '?'
+		for _, c := range []struct{ got, want SPN }{
+			{MAP2MAX(spn, bq), baselineMAP2MAX(spn, bq)},
+			{reduced(spn, q), baselineStage(spn, q)},
+		} {
+			if len(c.got.Nodes) != len(c.want.Nodes) {
+				t.Fatalf("%d nodes, want %d", len(c.got.Nodes), len(c.want.Nodes))
+			}
+			for _, x := range assignments(c.want.Schema) {
+				if p, want := c.got.EvalX(x), c.want.EvalX(x); !approxEqual(p, want) {
+					t.Fatalf("EvalX(%v) = %v, want %v", x, p, want)
+				}
+			}
+		}
+	}
+}
+
+func TestReduceMixed(t *testing.T) {
+	// Sum(.3 Prd(A=1, B=0), .7 [B=1]) with B observed to 1: the reduced
+	// product is over A while the leaf of B is a constant.
+	a1 := &Trm{Kth: 0, Value: 1, id: 0}
+	b0 := &Trm{Kth: 1, Value: 0, id: 1}
+	b1 := &Trm{Kth: 1, Value: 1, id: 2}
+	p := &Prd{Edges: []PrdEdge{{a1}, {b0}}, id: 3}
+	s := &Sum{Edges: []SumEdge{{math.Log(.3), p}, {math.Log(.7), b1}}, id: 4}
+	spn := SPN{[]Node{a1, b0, b1, p, s}, []int{2, 2}}
+	for _, q := range [][]int{{Query, 1}, {Query, 0}, {Query, Marginalize}, {Query, Query}, {1, Query}} {
+		red, m := Reduce(spn, q)
+		checkReduce(t, spn, red, m)
+	}
+	red, _ := Reduce(spn, []int{Query, 1})
+	for _, x := range [][]int{{0}, {1}} {
+		if p := red.EvalX(x); !approxEqual(p, math.Log(.7)) {
+			t.Fatalf("EvalX(%v) = %v, want log .7", x, p)
+		}
+	}
+	// Sum(.2 [X0=0], .8 [X0=1]) with X0 observed to 1: the root is a constant
+	// and X1 is missing from its scope.
+	x0 := &Trm{Kth: 0, Value: 0, id: 0}
+	x1 := &Trm{Kth: 0, Value: 1, id: 1}
+	s = &Sum{Edges: []SumEdge{{math.Log(.2), x0}, {math.Log(.8), x1}}, id: 2}
+	spn = SPN{[]Node{x0, x1, s}, []int{2, 2}}
+	for _, q := range [][]int{{1, Query}, {Marginalize, Query}, {Query, Query}} {
+		red, m := Reduce(spn, q)
+		checkReduce(t, spn, red, m)
+	}
+	red, _ = Reduce(spn, []int{1, Query})
+	for _, x := range [][]int{{0}, {1}} {
+		if p := red.EvalX(x); !approxEqual(p, math.Log(.8)) {
+			t.Fatalf("EvalX(%v) = %v, want log .8", x, p)
+		}
+	}
+}
+
+// reduced is Reduce without the mapping of the variables.
+func reduced(spn SPN, q []int) SPN {
+	red, _ := Reduce(spn, q)
+	return red
+}
+
+// baselineMAP2MAX is MAP2MAX before Reduce, for binary variables.
+func baselineMAP2MAX(spn SPN, q []byte) SPN {
+	c := make([]int, len(q))
+	for i, b := range q {
+		switch b {
+		case '?':
+			c[i] = -1
+		case '*':
+			c[i] = -2
+		default:
+			c[i] = int(b - '0')
+		}
+	}
+	return baselineStage(spn, c)
+}
+
+// baselineStage is stage before Reduce, extended with -2 for the '*' of
+// MAP2MAX.
+func baselineStage(spn SPN, q []int) SPN {
+	idMap := map[int]int{}
+	varCnt := 0
+	schema := make([]int, 0, len(spn.Schema))
+	for i, c := range q {
+		if c == -1 {
+			idMap[i] = varCnt
+			varCnt++
+			schema = append(schema, spn.Schema[i])
+		}
+	}
+	if varCnt == 0 {
+		log.Println(q)
+		log.Fatal("q has no -1")
+	}
+	nn := len(spn.Nodes)
+	ns := make([]Node, nn+1)
+	we := make([]float64, nn)
+	for i, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Trm:
+			if q[n.Kth] == -1 {
+				ns[i] = &Trm{Kth: idMap[n.Kth], Value: n.Value}
+			} else {
+				w := math.Inf(-1)
+				if n.Value == 0 && (q[n.Kth] == 0 || q[n.Kth] == -2) {
+					w = 0
+				}
+				if n.Value == 1 && (q[n.Kth] == 1 || q[n.Kth] == -2) {
+					w = 0
+				}
+				we[i] = w
+			}
+		case *Sum:
+			if ns[n.Edges[0].Node.ID()] == nil {
+				we[i] = logSumExpF(len(n.Edges), func(k int) float64 {
+					return n.Edges[k].Weight + we[n.Edges[k].Node.ID()]
+				})
+			} else {
+				es := make([]SumEdge, len(n.Edges))
+				for j, e := range n.Edges {
+					es[j] = SumEdge{e.Weight + we[e.Node.ID()], ns[e.Node.ID()]}
+				}
+				ns[i] = &Sum{Edges: es}
+			}
+		case *Prd:
+			es := make([]PrdEdge, 0, len(n.Edges))
+			w := 0.0
+			for _, e := range n.Edges {
+				w += we[e.Node.ID()]
+				if ns[e.Node.ID()] != nil {
+					es = append(es, PrdEdge{ns[e.Node.ID()]})
+				}
+			}
+			we[i] = w
+			if len(es) > 0 {
+				ns[i] = &Prd{Edges: es}
+			}
+		}
+	}
+	if _, ok := ns[nn-1].(*Sum); !ok {
+		ns[nn] = &Sum{Edges: []SumEdge{{we[nn-1], ns[nn-1]}}}
+	}
+	nodes := make([]Node, 0, nn+1)
+	for _, n := range ns {
+		if n != nil {
+			n.SetID(len(nodes))
+			nodes = append(nodes, n)
+		}
+	}
+	return SPN{nodes, schema}
+}
@@ -0,0 +1,73 @@
+package maxspn
+
+import (
+	"math"
+	"math/rand"
+	"sync"
+)
+
+var ( // Main API
+	_ = Sample
+	_ = SampleParallel
+)
+
+// sampleBlock is the number of samples drawn from one stream of
+// SampleParallel.
+const sampleBlock = 1024
+
+// Sample draws n assignments from P(X | e), one per row, using r. Observed
+// variables keep their values. It returns nil if P(e) is 0.
+func Sample(spn SPN, e Evidence, n int, r *rand.Rand) [][]int {
+	val := spn.Eval(X2Ass(e.X(spn.Schema), spn.Schema))
+	if math.IsInf(val[len(val)-1], -1) {
+		return nil
+	}
+	xs := make([][]int, n)
+	for i := range xs {
+		xs[i] = prb1(spn, val, r.Float64)
+	}
+	return xs
+}
+
+// SampleParallel is Sample on workers goroutines. Every block of sampleBlock
+// rows is drawn from its own stream derived from seed, so the result depends
+// only on seed and not on workers.
+func SampleParallel(spn SPN, e Evidence, n int, seed int64, workers int) [][]int {
+	val := spn.Eval(X2Ass(e.X(spn.Schema), spn.Schema))
+	if math.IsInf(val[len(val)-1], -1) {
+		return nil
+	}
+	if workers < 1 {
+		workers = 1
+	}
+	xs := make([][]int, n)
+	blocks := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range blocks {
+				r := rand.New(rand.NewSource(streamSeed(seed, b)))
+				for i := b * sampleBlock; i < n && i < (b+1)*sampleBlock; i++ {
+					xs[i] = prb1(spn, val, r.Float64)
+				}
+			}
+		}()
+	}
+	for b := 0; b*sampleBlock < n; b++ {
+		blocks <- b
+	}
+	close(blocks)
+	wg.Wait()
+	return xs
+}
+
+// streamSeed mixes seed and the stream index with splitmix64, so that
+// neighbouring streams are not correlated.
+func streamSeed(seed int64, stream int) int64 {
+	z := uint64(seed) + uint64(stream+1)*0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return int64(z ^ (z >> 31))
+}
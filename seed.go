@@ -0,0 +1,61 @@
+package maxspn
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+var ( // Main API
+	_ = Seed
+	_ = ExactSeeded
+)
+
+// seedShare is the share of the timeout of ExactSeeded and Solve spent on
+// Seed, the rest being left to the exact search.
+const seedShare = 0.1
+
+// Seed returns the best assignment among ApproxBT, ApproxNG and the k best
+// trees of ApproxKBT, evaluated with EvalX. Its P is a valid baseline for
+// the exact solvers.
+func Seed(spn SPN, k int, timeout int) XP {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	return seed(ctx, spn, k)
+}
+
+func seed(ctx context.Context, spn SPN, k int) XP {
+	xs := kbt(ctx, spn, k)
+	xs = append(xs, ApproxBT(spn), ApproxNG(spn))
+	return maxXP(evalXBatch(spn, xs))
+}
+
+// seedTimeout is the time given to Seed out of timeout seconds.
+func seedTimeout(timeout int) time.Duration {
+	return time.Duration(seedShare * float64(timeout) * float64(time.Second))
+}
+
+// ExactSeeded runs an exact solver (ExactMC, ExactFC, ...) with its baseline
+// computed by Seed within a share of timeout. The solver gets timeout minus
+// the seeding time rounded up to whole seconds, but at least 1 second when
+// timeout is, so the total may exceed timeout by the seeding time when
+// timeout is 1. The seed assignment is returned as fallback when the solver
+// does not improve on it; otherwise X is nil, since the exact solvers only
+// report values.
+func ExactSeeded(exact func(SPN, float64, int) float64, spn SPN, k int, timeout int) XP {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(context.Background(), seedTimeout(timeout))
+	xp := seed(ctx, spn, k)
+	cancel()
+	rest := timeout - int(math.Ceil(time.Since(start).Seconds()))
+	if rest < 1 {
+		rest = 1
+		if timeout < 1 {
+			rest = timeout
+		}
+	}
+	if p := exact(spn, xp.P, rest); p > xp.P {
+		return XP{X: nil, P: p}
+	}
+	return xp
+}
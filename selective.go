@@ -0,0 +1,111 @@
+package maxspn
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+var ( // Main API
+	_ = IsSelective
+	_ = SelectiveNodes
+	_ = Solve
+)
+
+// IsSelective reports whether every sum node of spn is selective, see
+// SelectiveNodes. On selective SPNs ApproxBT is exact, since every assignment
+// has a single induced tree.
+func IsSelective(spn SPN) bool {
+	for _, s := range SelectiveNodes(spn) {
+		if !s {
+			return false
+		}
+	}
+	return true
+}
+
+// SelectiveNodes reports for every node whether it is a sum node whose
+// children have pairwise disjoint supports, or not a sum node. The check is
+// sufficient only: two children are known to be disjoint when some variable
+// can take no common value in both.
+func SelectiveNodes(spn SPN) []bool {
+	dom := domains(spn)
+	sel := make([]bool, len(spn.Nodes))
+	for i, n := range spn.Nodes {
+		sel[i] = true
+		if n, ok := n.(*Sum); ok {
+			for a := range n.Edges {
+				for b := a + 1; b < len(n.Edges) && sel[i]; b++ {
+					sel[i] = disjoint(dom[n.Edges[a].Node.ID()], dom[n.Edges[b].Node.ID()])
+				}
+			}
+		}
+	}
+	return sel
+}
+
+// Solve returns the MAP assignment of spn with bounds on its value. On
+// selective SPNs it is ApproxBT's assignment, proven optimal. Otherwise Seed
+// runs within a share of timeout, and ExactFCnOnS's search improves on it
+// for the rest of timeout; the assignment is the best one found, and it is
+// proven optimal when the bounds are equal.
+func Solve(spn SPN, timeout int) (XP, Bound) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+	defer cancel()
+	if IsSelective(spn) {
+		x := ApproxBT(spn)
+		p := spn.EvalX(x)
+		return XP{x, p}, Bound{p, p}
+	}
+	sctx, scancel := context.WithTimeout(ctx, seedTimeout(timeout))
+	xp := seed(sctx, spn, 10)
+	scancel()
+	s := &search{ctx: ctx, order: OrderMaxDerivative, fc: true, stage: true, budget: -1, open: math.Inf(-1)}
+	if p := s.dfs(s.network(spn), freeX(len(spn.Schema)), math.NaN(), xp.P); p > xp.P {
+		xp = XP{s.best, p}
+	}
+	return xp, s.bound(xp.P)
+}
+
+// domains over-approximates, for every node, the values each variable of its
+// scope takes in its support, as a bit set. Values beyond 63 are not tracked
+// and make the variable unconstrained.
+func domains(spn SPN) []map[int]uint64 {
+	dom := make([]map[int]uint64, len(spn.Nodes))
+	for i, n := range spn.Nodes {
+		dom[i] = map[int]uint64{}
+		switch n := n.(type) {
+		case *Trm:
+			if n.Value < 64 {
+				dom[i][n.Kth] = 1 << uint(n.Value)
+			} else {
+				dom[i][n.Kth] = ^uint64(0)
+			}
+		case *Sum:
+			for _, e := range n.Edges {
+				for k, m := range dom[e.Node.ID()] {
+					dom[i][k] |= m
+				}
+			}
+		case *Prd:
+			for _, e := range n.Edges {
+				for k, m := range dom[e.Node.ID()] {
+					if d, ok := dom[i][k]; ok {
+						m &= d
+					}
+					dom[i][k] = m
+				}
+			}
+		}
+	}
+	return dom
+}
+
+func disjoint(a, b map[int]uint64) bool {
+	for k, m := range a {
+		if d, ok := b[k]; ok && m&d == 0 {
+			return true
+		}
+	}
+	return false
+}
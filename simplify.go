@@ -0,0 +1,191 @@
+package maxspn
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+var ( // Main API
+	_ = Simplify
+)
+
+// Simplify returns an SPN with the same distribution as spn (the same EvalX
+// values) and no more nodes: sum edges with -Inf weight and the nodes that
+// are zero because of them are removed, sum and product nodes with a single
+// child are collapsed into their parents, sums of sums and products of
+// products whose children have a single parent are merged, duplicate sum
+// edges are combined, identical sub-networks are shared, and IDs are
+// renumbered. An SPN whose root is zero is returned unchanged.
+func Simplify(spn SPN) SPN {
+	parents := make([]int, len(spn.Nodes))
+	for _, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Sum:
+			for _, e := range n.Edges {
+				parents[e.Node.ID()]++
+			}
+		case *Prd:
+			for _, e := range n.Edges {
+				parents[e.Node.ID()]++
+			}
+		}
+	}
+	s := &simplifier{seen: map[string]Node{}}
+	out := make([]ref, len(spn.Nodes))
+	for i, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Trm:
+			out[i] = ref{s.cons(&Trm{Kth: n.Kth, Value: n.Value}), 0}
+		case *Sum:
+			var es []SumEdge
+			for _, e := range n.Edges {
+				r := out[e.Node.ID()]
+				w := e.Weight + r.off
+				if r.node == nil || math.IsInf(w, -1) {
+					continue
+				}
+				if c, ok := r.node.(*Sum); ok && parents[e.Node.ID()] == 1 {
+					for _, ce := range c.Edges {
+						es = append(es, SumEdge{w + ce.Weight, ce.Node})
+					}
+				} else {
+					es = append(es, SumEdge{w, r.node})
+				}
+			}
+			es = mergeSumEdges(es)
+			switch len(es) {
+			case 0:
+				out[i] = ref{}
+			case 1:
+				out[i] = ref{es[0].Node, es[0].Weight}
+			default:
+				out[i] = ref{s.cons(&Sum{Edges: es}), 0}
+			}
+		case *Prd:
+			var es []PrdEdge
+			off := 0.0
+			zero := false
+			for _, e := range n.Edges {
+				r := out[e.Node.ID()]
+				if r.node == nil {
+					zero = true
+					break
+				}
+				off += r.off
+				if c, ok := r.node.(*Prd); ok && parents[e.Node.ID()] == 1 {
+					es = append(es, c.Edges...)
+				} else {
+					es = append(es, PrdEdge{r.node})
+				}
+			}
+			sort.Slice(es, func(a, b int) bool { return es[a].Node.ID() < es[b].Node.ID() })
+			switch {
+			case zero:
+				out[i] = ref{}
+			case len(es) == 1:
+				out[i] = ref{es[0].Node, off}
+			default:
+				out[i] = ref{s.cons(&Prd{Edges: es}), off}
+			}
+		}
+	}
+	root := out[len(out)-1]
+	if root.node == nil {
+		return spn
+	}
+	if r, ok := root.node.(*Sum); ok && root.off != 0 {
+		es := make([]SumEdge, len(r.Edges))
+		for j, e := range r.Edges {
+			es[j] = SumEdge{e.Weight + root.off, e.Node}
+		}
+		root.node = s.cons(&Sum{Edges: es})
+	} else if root.off != 0 {
+		root.node = s.cons(&Sum{Edges: []SumEdge{{root.off, root.node}}})
+	}
+	return SPN{reachable(s.nodes, root.node), spn.Schema}
+}
+
+// ref is a node of the simplified network scaled by off in log domain. A nil
+// node is zero.
+type ref struct {
+	node Node
+	off  float64
+}
+
+type simplifier struct {
+	nodes []Node
+	seen  map[string]Node // hash-consing of nodes by kind, children and weights
+}
+
+func (s *simplifier) cons(n Node) Node {
+	var b strings.Builder
+	switch n := n.(type) {
+	case *Trm:
+		fmt.Fprintf(&b, "v %d %d", n.Kth, n.Value)
+	case *Sum:
+		b.WriteString("+")
+		for _, e := range n.Edges {
+			fmt.Fprintf(&b, " %d %x", e.Node.ID(), math.Float64bits(e.Weight))
+		}
+	case *Prd:
+		b.WriteString("*")
+		for _, e := range n.Edges {
+			fmt.Fprintf(&b, " %d", e.Node.ID())
+		}
+	}
+	if m, ok := s.seen[b.String()]; ok {
+		return m
+	}
+	n.SetID(len(s.nodes))
+	s.nodes = append(s.nodes, n)
+	s.seen[b.String()] = n
+	return n
+}
+
+// mergeSumEdges combines edges to the same child and sorts them by child.
+func mergeSumEdges(es []SumEdge) []SumEdge {
+	sort.SliceStable(es, func(a, b int) bool { return es[a].Node.ID() < es[b].Node.ID() })
+	res := es[:0]
+	for _, e := range es {
+		if k := len(res) - 1; k >= 0 && res[k].Node == e.Node {
+			res[k].Weight = logSumExp(res[k].Weight, e.Weight)
+		} else {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// reachable returns the nodes reachable from root, in the order of nodes,
+// with renumbered IDs.
+func reachable(nodes []Node, root Node) []Node {
+	reach := make([]bool, len(nodes))
+	reach[root.ID()] = true
+	for i := root.ID(); i >= 0; i-- {
+		if !reach[i] {
+			continue
+		}
+		switch n := nodes[i].(type) {
+		case *Sum:
+			for _, e := range n.Edges {
+				reach[e.Node.ID()] = true
+			}
+		case *Prd:
+			for _, e := range n.Edges {
+				reach[e.Node.ID()] = true
+			}
+		}
+	}
+	var res []Node
+	for i, n := range nodes {
+		if reach[i] {
+			res = append(res, n)
+		}
+	}
+	for i, n := range res {
+		n.SetID(i)
+	}
+	return res
+}
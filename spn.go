@@ -2,6 +2,7 @@ package maxspn
 
 import (
 	"bytes"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"math"
@@ -10,6 +11,7 @@ import (
 
 var ( // Main API
 	_ = LoadSPN
+	_ = SaveSPN
 	_ = MAP2MAX
 )
 
@@ -111,6 +113,40 @@ func LoadSPN(filename string) SPN {
 	return SPN{nodes, schema}
 }
 
+// SaveSPN writes spn in the format read by LoadSPN.
+func SaveSPN(spn SPN, filename string) {
+	var buf bytes.Buffer
+	buf.WriteString("(")
+	for i, s := range spn.Schema {
+		if i > 0 {
+			buf.WriteString(" ")
+		}
+		fmt.Fprint(&buf, s)
+	}
+	buf.WriteString(")\n")
+	for _, n := range spn.Nodes {
+		switch n := n.(type) {
+		case *Trm:
+			fmt.Fprintf(&buf, "v %d %d", n.Kth, n.Value)
+		case *Sum:
+			buf.WriteString("+")
+			for _, e := range n.Edges {
+				fmt.Fprintf(&buf, " %d %s", e.Node.ID(), strconv.FormatFloat(e.Weight, 'g', -1, 64))
+			}
+		case *Prd:
+			buf.WriteString("*")
+			for _, e := range n.Edges {
+				fmt.Fprintf(&buf, " %d", e.Node.ID())
+			}
+		}
+		buf.WriteString("\n")
+	}
+	buf.WriteString("EOF\n")
+	if err := ioutil.WriteFile(filename, buf.Bytes(), 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
 func (spn SPN) EvalX(x []int) float64 {
 	val := spn.Eval(X2Ass(x, spn.Schema))
 	return val[len(val)-1]
@@ -188,77 +224,28 @@ func (spn SPN) Derivative(ass [][]float64) []float64 {
 	return dr
 }
 
+// MAP2MAX reduces spn to the '?' variables of the query q, with the other
+// variables observed ('0'-'9') or marginalized ('*'), see Reduce.
 func MAP2MAX(spn SPN, q []byte) SPN {
-	idMap := map[int]int{}
+	qi := make([]int, len(q))
 	varCnt := 0
-	schema := make([]int, 0, len(spn.Schema))
 	for i, c := range q {
-		if c == '?' {
-			idMap[i] = varCnt
+		switch c {
+		case '?':
+			qi[i] = Query
 			varCnt++
-			schema = append(schema, spn.Schema[i])
+		case '*':
+			qi[i] = Marginalize
+		default:
+			qi[i] = int(c - '0')
 		}
 	}
 	if varCnt == 0 {
 		log.Println(string(q))
 		log.Fatal("QuerySPN no '?'")
 	}
-	nn := len(spn.Nodes)
-	ns := make([]Node, nn+1)
-	we := make([]float64, nn)
-	for i, n := range spn.Nodes {
-		switch n := n.(type) {
-		case *Trm:
-			if q[n.Kth] == '?' {
-				ns[i] = &Trm{Kth: idMap[n.Kth], Value: n.Value}
-			} else {
-				w := math.Inf(-1)
-				if n.Value == 0 && (q[n.Kth] == '0' || q[n.Kth] == '*') {
-					w = 0
-				}
-				if n.Value == 1 && (q[n.Kth] == '1' || q[n.Kth] == '*') {
-					w = 0
-				}
-				we[i] = w
-			}
-		case *Sum:
-			if ns[n.Edges[0].Node.ID()] == nil {
-				we[i] = logSumExpF(len(n.Edges), func(k int) float64 {
-					return n.Edges[k].Weight + we[n.Edges[k].Node.ID()]
-				})
-			} else {
-				es := make([]SumEdge, len(n.Edges))
-				for j, e := range n.Edges {
-					es[j] = SumEdge{e.Weight + we[e.Node.ID()], ns[e.Node.ID()]}
-				}
-				ns[i] = &Sum{Edges: es}
-			}
-		case *Prd:
-			es := make([]PrdEdge, 0, len(n.Edges))
-			w := 0.0
-			for _, e := range n.Edges {
-				w += we[e.Node.ID()]
-				if ns[e.Node.ID()] != nil {
-					es = append(es, PrdEdge{ns[e.Node.ID()]})
-				}
-			}
-			we[i] = w
-			if len(es) > 0 {
-				ns[i] = &Prd{Edges: es}
-			}
-		}
-	}
-	if _, ok := ns[nn-1].(*Sum); !ok {
-		ns[nn] = &Sum{Edges: []SumEdge{{we[nn-1], ns[nn-1]}}}
-	}
-	nodes := make([]Node, 0, nn+1)
-	for _, n := range ns {
-		if n != nil {
-			n.SetID(len(nodes))
-			nodes = append(nodes, n)
-		}
-	}
-	return SPN{nodes, schema}
+	red, _ := Reduce(spn, qi)
+	return red
 }
 
 func X2Ass(x []int, schema []int) [][]float64 {
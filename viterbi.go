@@ -0,0 +1,94 @@
+package maxspn
+
+import "math"
+
+var ( // Main API
+	_ = Viterbi
+)
+
+// Viterbi fits the sum weights of spn to the assignments xs (-1 for missing
+// values) by hard EM: every epoch counts, for each instance, the sum edges of
+// its best induced tree (see MaxTree) and sets the weights to the counts
+// smoothed with alpha. It returns the fitted SPN with the average
+// log-likelihood of xs after every epoch, stopping after epochs epochs or
+// once the selected trees no longer change.
+func Viterbi(spn SPN, xs [][]int, alpha float64, epochs int) (SPN, []float64) {
+	f := Compile(spn)
+	var trace []float64
+	var last []float64
+	for epoch := 0; epoch < epochs; epoch++ {
+		cnt := make([]float64, len(f.Weight))
+		for _, x := range xs {
+			f.countMaxTree(x, cnt)
+		}
+		f.maximize(cnt, alpha)
+		ll := 0.0
+		for _, x := range xs {
+			ll += f.EvalX(x)
+		}
+		trace = append(trace, ll/float64(len(xs)))
+		if sameFloats(cnt, last) {
+			break
+		}
+		last = cnt
+	}
+	return f.SPN(), trace
+}
+
+// countMaxTree adds 1 to cnt for every sum edge of the best induced tree of x.
+func (f *Flat) countMaxTree(x []int, cnt []float64) {
+	val := make([]float64, len(f.Kind))
+	best := make([]int32, len(f.Kind)) // chosen edge of sum nodes
+	for i, k := range f.Kind {
+		lo, hi := f.Start[i], f.Start[i+1]
+		switch k {
+		case KindTrm:
+			if xi := x[f.Kth[i]]; xi != -1 && xi != int(f.Value[i]) {
+				val[i] = math.Inf(-1)
+			}
+		case KindSum:
+			val[i], best[i] = math.Inf(-1), -1
+			for j := lo; j < hi; j++ {
+				if crt := f.Weight[j] + val[f.Child[j]]; val[i] < crt {
+					val[i], best[i] = crt, j
+				}
+			}
+		case KindPrd:
+			for j := lo; j < hi; j++ {
+				val[i] += val[f.Child[j]]
+			}
+		}
+	}
+	root := len(f.Kind) - 1
+	if math.IsInf(val[root], -1) {
+		return
+	}
+	reach := make([]bool, len(f.Kind))
+	reach[root] = true
+	for i := root; i >= 0; i-- {
+		if !reach[i] {
+			continue
+		}
+		switch f.Kind[i] {
+		case KindSum:
+			cnt[best[i]]++
+			reach[f.Child[best[i]]] = true
+		case KindPrd:
+			for j := f.Start[i]; j < f.Start[i+1]; j++ {
+				reach[f.Child[j]] = true
+			}
+		}
+	}
+}
+
+func sameFloats(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}